@@ -3,9 +3,9 @@ module identifier
 go 1.16
 
 require (
-	github.com/gin-gonic/gin v1.7.4 // indirect
-	github.com/iamipanda/ygopro-data v0.0.0-20190116110429-360968dc5c66 // indirect
-	github.com/itchio/lzma v0.0.0-20190703113020-d3e24e3e3d49 // indirect
-	github.com/mattn/go-sqlite3 v1.14.8 // indirect
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7 // indirect
+	github.com/gin-gonic/gin v1.7.4
+	github.com/iamipanda/ygopro-data v0.0.0-20190116110429-360968dc5c66
+	github.com/itchio/lzma v0.0.0-20190703113020-d3e24e3e3d49
+	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 )
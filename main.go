@@ -10,6 +10,33 @@ import (
 func main() {
 	ygopro_data.LuaPath = filepath.Join(os.Getenv("GOPATH"), "pkg/mod/github.com/iamipanda/ygopro-data@v0.0.0-20190116110429-360968dc5c66/Constant.lua")
 
-	ygopro_deck_identifier.Initialize()
-	ygopro_deck_identifier.StartServer()
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	switch command {
+	case "fmt":
+		os.Exit(ygopro_deck_identifier.FormatCommand(os.Args[2:]))
+	case "lint":
+		os.Exit(ygopro_deck_identifier.LintCommand(os.Args[2:]))
+	case "test":
+		os.Exit(ygopro_deck_identifier.TestCommand(os.Args[2:]))
+	case "tune":
+		os.Exit(ygopro_deck_identifier.TuneCommand(os.Args[2:]))
+	case "coverage":
+		os.Exit(ygopro_deck_identifier.CoverageCommand(os.Args[2:]))
+	case "cluster":
+		os.Exit(ygopro_deck_identifier.ClusterCommand(os.Args[2:]))
+	case "bench":
+		os.Exit(ygopro_deck_identifier.BenchCommand(os.Args[2:]))
+	case "lsp":
+		// The protocol owns stdout, so anything the libraries print goes to stderr instead.
+		output := os.Stdout
+		os.Stdout = os.Stderr
+		ygopro_deck_identifier.Initialize()
+		ygopro_deck_identifier.StartLanguageServer(os.Stdin, output)
+	default:
+		ygopro_deck_identifier.Initialize()
+		ygopro_deck_identifier.StartServer()
+	}
 }
@@ -0,0 +1,91 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"runtime"
+	"sync"
+)
+
+// BatchDeck is one deck of a batch, given either as text in Deck, in any format DecodeDeck reads, or as passcode lists.
+type BatchDeck struct {
+	Key   string `json:"key"`
+	Deck  string `json:"deck"`
+	Main  []int  `json:"main"`
+	Extra []int  `json:"extra"`
+	Side  []int  `json:"side"`
+}
+
+// BatchResult holds the recognition of one deck, or the Error which kept the deck from being read.
+type BatchResult struct {
+	Key        string
+	Result     *Result
+	Generation uint64
+	Error      string
+}
+
+func (batchDeck *BatchDeck) Load() (ygopro_data.Deck, error) {
+	if len(batchDeck.Deck) > 0 {
+		deck, _, err := DecodeDeck(batchDeck.Deck)
+		return deck, err
+	}
+	deck := ygopro_data.Deck{}
+	deck.Main = append(deck.Main, batchDeck.Main...)
+	deck.Ex = append(deck.Ex, batchDeck.Extra...)
+	deck.Side = append(deck.Side, batchDeck.Side...)
+	return deck, nil
+}
+
+// RecognizeBatch recognizes the decks on the given number of workers and hands every result to handle,
+// one at a time and in completion order. It returns once all decks are done.
+func (identifier *Identifier) RecognizeBatch(decks []BatchDeck, separate bool, workers int, handle func(BatchResult)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan int)
+	results := make(chan BatchResult)
+	var group sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for index := range jobs {
+				loaded, err := decks[index].Load()
+				if err != nil {
+					results <- BatchResult{Key: decks[index].Key, Generation: identifier.Generation, Error: err.Error()}
+					continue
+				}
+				deck := PrepareDeck(loaded, separate)
+				result := identifier.Recognize(deck)
+				if result != nil {
+					result.processAffixAndGetName(true)
+				}
+				results <- BatchResult{Key: decks[index].Key, Result: result, Generation: identifier.Generation}
+			}
+		}()
+	}
+	go func() {
+		for index := range decks {
+			jobs <- index
+		}
+		close(jobs)
+		group.Wait()
+		close(results)
+	}()
+	for result := range results {
+		handle(result)
+	}
+}
+
+// RecognizeBatch runs the whole batch on the current version, so every deck sees the same definitions
+// even if a reload is published in the middle.
+func (identifier *IdentifierWrapper) RecognizeBatch(decks []BatchDeck, separate bool, handle func(BatchResult)) {
+	current := identifier.Current()
+	current.RecognizeBatch(decks, separate, runtime.NumCPU(), func(result BatchResult) {
+		if len(result.Error) == 0 {
+			json := result.Result.ToJson()
+			tags, _ := json["tag"].([]string)
+			Metrics.recordRecognition(current.Name, json["deck"].(string), tags)
+		}
+		handle(result)
+	})
+}
@@ -0,0 +1,151 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PlanBenchmark compares the evaluation plan against the linear loop over the same decks.
+// Evaluated counts the deck types the plan had to judge, summed over the decks of one round.
+type PlanBenchmark struct {
+	Decks      int
+	Rounds     int
+	DeckTypes  int
+	Linear     time.Duration
+	Indexed    time.Duration
+	Evaluated  int
+	Mismatches []PlanMismatch
+}
+
+// PlanMismatch is a deck the plan recognized differently from the linear loop.
+type PlanMismatch struct {
+	Index   int
+	Linear  string
+	Indexed string
+}
+
+// BenchmarkPlan recognizes every deck both ways, checking the answers agree and timing each way over the given rounds.
+func (identifier *Identifier) BenchmarkPlan(decks []ygopro_data.Deck, rounds int) PlanBenchmark {
+	benchmark := PlanBenchmark{Decks: len(decks), Rounds: rounds, DeckTypes: len(identifier.Decks)}
+	plan := identifier.plan
+	if plan == nil {
+		plan = newEvaluationPlan(identifier)
+	}
+	for index, deck := range decks {
+		linear := resultSignature(identifier.RecognizeLinearly(deck))
+		indexed := resultSignature(identifier.combine(plan.recognize(deck)))
+		if linear != indexed {
+			benchmark.Mismatches = append(benchmark.Mismatches, PlanMismatch{index, linear, indexed})
+		}
+		benchmark.Evaluated += len(plan.deckIndex.candidates(&deck))
+	}
+	start := time.Now()
+	for round := 0; round < rounds; round++ {
+		for _, deck := range decks {
+			identifier.RecognizeLinearly(deck)
+		}
+	}
+	benchmark.Linear = time.Since(start)
+	start = time.Now()
+	for round := 0; round < rounds; round++ {
+		for _, deck := range decks {
+			identifier.combine(plan.recognize(deck))
+		}
+	}
+	benchmark.Indexed = time.Since(start)
+	return benchmark
+}
+
+func resultSignature(result *Result) string {
+	if result == nil {
+		return ""
+	}
+	names := []string{result.Deck.Name}
+	for _, tag := range result.Tags {
+		names = append(names, tag.Name)
+	}
+	return strings.Join(names, "|")
+}
+
+// DeckFile is a deck read from a .ydk file.
+type DeckFile struct {
+	Path string
+	Deck ygopro_data.Deck
+}
+
+// LoadDeckFiles reads every .ydk file under the given paths in name order, ready to be recognized.
+func LoadDeckFiles(paths []string) ([]DeckFile, Diagnostics) {
+	files := make([]DeckFile, 0)
+	diagnostics := walkDeckFiles(paths, func(path string, content string) {
+		files = append(files, DeckFile{path, PrepareDeck(ygopro_data.LoadYdkFromString(content), false)})
+	})
+	return files, diagnostics
+}
+
+// walkDeckFiles hands the content of every .ydk file under the given paths to visit, in name order.
+// A path naming a file is read whatever its extension.
+func walkDeckFiles(paths []string, visit func(path string, content string)) Diagnostics {
+	diagnostics := make(Diagnostics, 0)
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				diagnostics = append(diagnostics, newDiagnostic(DIAGNOSTIC_ERROR, "file-unreadable", nil, newOriginMessage(0, "", path), "Failed to read file: "+err.Error()))
+				return nil
+			}
+			if info.IsDir() || (path != root && !strings.HasSuffix(path, ".ydk")) {
+				return nil
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				diagnostics = append(diagnostics, newDiagnostic(DIAGNOSTIC_ERROR, "file-unreadable", nil, newOriginMessage(0, "", path), "Failed to read file: "+err.Error()))
+				return nil
+			}
+			visit(path, string(content))
+			return nil
+		})
+	}
+	return diagnostics
+}
+
+// RandomDecks makes decks for benchmarks. Half of the main deck comes from the cards the definitions ask for,
+// so the decks hit the index the way real ones do, the rest is drawn from the whole environment.
+func (identifier *Identifier) RandomDecks(count int, seed int64) []ygopro_data.Deck {
+	random := rand.New(rand.NewSource(seed))
+	all := make([]int, 0, len(identifier.BindingEnvironment.Cards))
+	for id := range identifier.BindingEnvironment.Cards {
+		all = append(all, id)
+	}
+	wanted := make([]int, 0)
+	if identifier.plan != nil {
+		for id := range identifier.plan.deckIndex.byCard {
+			wanted = append(wanted, id)
+		}
+	}
+	sort.Ints(all)
+	sort.Ints(wanted)
+	if len(all) == 0 {
+		return nil
+	}
+	decks := make([]ygopro_data.Deck, 0, count)
+	for i := 0; i < count; i++ {
+		deck := ygopro_data.Deck{}
+		for j := 0; j < 40; j++ {
+			if len(wanted) > 0 && random.Intn(2) == 0 {
+				deck.Main = append(deck.Main, wanted[random.Intn(len(wanted))])
+			} else {
+				deck.Main = append(deck.Main, all[random.Intn(len(all))])
+			}
+		}
+		for j := random.Intn(16); j > 0; j-- {
+			deck.Ex = append(deck.Ex, all[random.Intn(len(all))])
+		}
+		decks = append(decks, PrepareDeck(deck, false))
+	}
+	return decks
+}
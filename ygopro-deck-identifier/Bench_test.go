@@ -0,0 +1,148 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"math/rand"
+	"testing"
+)
+
+// planDefinition asks for every kind of compiled restrain: cards, sets with and without repeated ids, groups,
+// and the size restrain judged on the deck itself.
+const planDefinition = `
+set: 影依核心
+  影依猎鹰
+  影依·巨人
+  影依·米德拉什
+
+set: 影依扩展
+  inner set: 影依核心
+  影依猎鹰
+  影依融合
+
+set: 杂项
+  机械士兵
+  机械战车
+  强欲之壶
+  神之宣告
+  XYZ大炮
+  影依融合
+
+deck: 影依 [5]
+  ! [影依核心] main >= 3 and 强欲之壶 >= 1
+  tag: 纯
+    set: 影依扩展 main >= 8
+
+deck: 机械 [3]
+  card: 机械士兵 main >= 2
+  or:
+    card: 机械战车 >= 2
+    card: XYZ大炮 ex >= 1
+
+deck: 杂鱼
+  set: 杂项 <= 20
+  size: main >= 40
+
+tag: 手坑
+  config: global
+  card: 神之宣告 >= 1
+
+tag: 无壶
+  config: global
+  card: 强欲之壶 <= 0
+`
+
+func TestPlanAgreesWithLinear(t *testing.T) {
+	identifier := newTestIdentifier(t, planDefinition)
+	kinds := make(map[string]bool)
+	var visit func(restrain compiledRestrain)
+	visit = func(restrain compiledRestrain) {
+		switch restrain := restrain.(type) {
+		case compiledCardRestrain:
+			kinds["card"] = true
+		case compiledSetRestrain:
+			kinds["set"] = true
+			if !restrain.unique {
+				kinds["repeated set"] = true
+			}
+		case compiledRestrainGroup:
+			kinds["group"] = true
+			for _, child := range restrain.restrains {
+				visit(child)
+			}
+		case fallbackRestrain:
+			kinds["fallback"] = true
+		}
+	}
+	for _, deck := range identifier.plan.compiledDecks {
+		for _, restrain := range deck.classification {
+			visit(restrain)
+		}
+		for _, tag := range deck.checkTags {
+			for _, restrain := range tag {
+				visit(restrain)
+			}
+		}
+	}
+	for _, kind := range []string{"card", "set", "repeated set", "group", "fallback"} {
+		if !kinds[kind] {
+			t.Errorf("the definition compiles no %v restrain", kind)
+		}
+	}
+
+	answers := make(map[string]int)
+	decks := append(identifier.RandomDecks(1000, 1), sparseTestDecks(1000, 1)...)
+	for index, deck := range decks {
+		linear := resultSignature(identifier.RecognizeLinearly(deck))
+		indexed := resultSignature(identifier.Recognize(deck))
+		if linear != indexed {
+			t.Fatalf("deck %d: linear [%v], indexed [%v]", index, linear, indexed)
+		}
+		answers[linear] += 1
+	}
+	if len(answers) < 4 {
+		t.Errorf("the random decks get only %d different answers: %v", len(answers), answers)
+	}
+	if benchmark := identifier.BenchmarkPlan(decks, 1); benchmark.Decks != len(decks) || len(benchmark.Mismatches) > 0 {
+		t.Errorf("BenchmarkPlan judged %d decks with %d mismatches", benchmark.Decks, len(benchmark.Mismatches))
+	}
+}
+
+// sparseTestDecks draws a quarter of the cards from the test cards and the rest from the fillers, so fewer
+// decks pass the definitions than with RandomDecks.
+func sparseTestDecks(count int, seed int64) []ygopro_data.Deck {
+	random := rand.New(rand.NewSource(seed))
+	decks := make([]ygopro_data.Deck, 0, count)
+	for i := 0; i < count; i++ {
+		deck := ygopro_data.Deck{}
+		for j := 0; j < 40; j++ {
+			if random.Intn(4) == 0 {
+				deck.Main = append(deck.Main, testCards[random.Intn(len(testCards))].Id)
+			} else {
+				deck.Main = append(deck.Main, 9001+random.Intn(testFillers))
+			}
+		}
+		for j := random.Intn(3); j > 0; j-- {
+			deck.Ex = append(deck.Ex, testCards[random.Intn(len(testCards))].Id)
+		}
+		decks = append(decks, PrepareDeck(deck, false))
+	}
+	return decks
+}
+
+func BenchmarkRecognizeLinearly(b *testing.B) {
+	identifier := newTestIdentifier(b, planDefinition)
+	decks := identifier.RandomDecks(1000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		identifier.RecognizeLinearly(decks[i%len(decks)])
+	}
+}
+
+func BenchmarkRecognizePlan(b *testing.B) {
+	identifier := newTestIdentifier(b, planDefinition)
+	decks := identifier.RandomDecks(1000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		identifier.Recognize(decks[i%len(decks)])
+	}
+}
@@ -0,0 +1,88 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"sort"
+)
+
+// Candidate is a deck type considered for a deck, with how many of its restrains passed.
+// Score is in [0, 1]: each passed restrain weighs 10/11 plus up to 1/11 growing with its margin,
+// failed restrains weigh nothing, and the sum is divided by the number of restrains.
+type Candidate struct {
+	Deck    Deck
+	Matched bool
+	Passed  int
+	Total   int
+	Score   float64
+}
+
+type CandidateSort []Candidate
+
+func (sort CandidateSort) Len() int           { return len(sort) }
+func (sort CandidateSort) Less(i, j int) bool { return sort[i].Score < sort[j].Score }
+func (sort CandidateSort) Swap(i, j int)      { sort[i], sort[j] = sort[j], sort[i] }
+
+// RecognizeCandidates returns every deck type matching the deck in priority order, followed by the near misses
+// (decks where most of the restrains passed) best score first. A limit <= 0 returns all of them.
+func (identifier *Identifier) RecognizeCandidates(deck ygopro_data.Deck, limit int) []Candidate {
+	matched := make([]Candidate, 0)
+	nearMisses := make([]Candidate, 0)
+	for _, deckType := range identifier.Decks {
+		candidate, ok := deckType.candidate(&deck)
+		if !ok {
+			continue
+		}
+		if candidate.Matched {
+			matched = append(matched, candidate)
+		} else if candidate.Passed*2 > candidate.Total {
+			nearMisses = append(nearMisses, candidate)
+		}
+	}
+	sort.Stable(sort.Reverse(CandidateSort(nearMisses)))
+	candidates := append(matched, nearMisses...)
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+func (deckType Deck) candidate(deck *ygopro_data.Deck) (Candidate, bool) {
+	if len(deckType.Restrains) == 0 {
+		return Candidate{}, false
+	}
+	is, answers := deckType.Classification.verboseJudge(deck)
+	candidate := Candidate{Deck: deckType, Matched: is, Total: len(answers)}
+	score := 0.0
+	for _, answer := range answers {
+		if !answer.is {
+			continue
+		}
+		candidate.Passed += 1
+		margin := float64(answer.margin())
+		if margin < 0 {
+			margin = 0
+		}
+		score += (10 + margin/(margin+1)) / 11
+	}
+	candidate.Score = score / float64(candidate.Total)
+	return candidate, true
+}
+
+// margin of the restrain on its own condition, 0 when the restrain has no condition to measure against.
+func (answer VerboseRestrainAnswer) margin() int {
+	switch restrain := answer.restrain.(type) {
+	case CardRestrain:
+		return restrain.Condition.Margin(answer.value)
+	case SetRestrain:
+		return restrain.Condition.Margin(answer.value)
+	case PropertyRestrain:
+		return restrain.Condition.Margin(answer.value)
+	case SizeRestrain:
+		return restrain.Condition.Margin(answer.value)
+	case CompareRestrain:
+		return restrain.Condition.Margin(answer.value) / EXPRESSION_SCALE
+	case RestrainGroup:
+		return restrain.Condition.Margin(answer.value)
+	}
+	return 0
+}
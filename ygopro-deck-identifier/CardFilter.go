@@ -0,0 +1,252 @@
+package ygopro_deck_identifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Property values follow the constants of the game scripts. The names are the constant names in lower case
+// and the zh-CN strings, so definitions may use either.
+var cardAttributeValues = map[string]int64{
+	"earth": 0x1, "water": 0x2, "fire": 0x4, "wind": 0x8, "light": 0x10, "dark": 0x20, "divine": 0x40,
+	"地": 0x1, "水": 0x2, "炎": 0x4, "风": 0x8, "光": 0x10, "暗": 0x20, "神": 0x40,
+}
+
+var cardRaceValues = map[string]int64{
+	"warrior": 0x1, "spellcaster": 0x2, "fairy": 0x4, "fiend": 0x8, "zombie": 0x10, "machine": 0x20,
+	"aqua": 0x40, "pyro": 0x80, "rock": 0x100, "windbeast": 0x200, "plant": 0x400, "insect": 0x800,
+	"thunder": 0x1000, "dragon": 0x2000, "beast": 0x4000, "beastwarrior": 0x8000, "dinosaur": 0x10000,
+	"fish": 0x20000, "seaserpent": 0x40000, "reptile": 0x80000, "psycho": 0x100000, "divine": 0x200000,
+	"creatorgod": 0x400000, "wyrm": 0x800000, "cyberse": 0x1000000, "illusion": 0x2000000,
+	"战士": 0x1, "魔法师": 0x2, "天使": 0x4, "恶魔": 0x8, "不死": 0x10, "机械": 0x20,
+	"水": 0x40, "炎": 0x80, "岩石": 0x100, "鸟兽": 0x200, "植物": 0x400, "昆虫": 0x800,
+	"雷": 0x1000, "龙": 0x2000, "兽": 0x4000, "兽战士": 0x8000, "恐龙": 0x10000,
+	"鱼": 0x20000, "海龙": 0x40000, "爬虫类": 0x80000, "念动力": 0x100000, "幻神兽": 0x200000,
+	"创造神": 0x400000, "幻龙": 0x800000, "电子界": 0x1000000, "幻想魔": 0x2000000,
+}
+
+var cardTypeValues = map[string]int64{
+	"monster": 0x1, "spell": 0x2, "trap": 0x4, "normal": 0x10, "effect": 0x20, "fusion": 0x40,
+	"ritual": 0x80, "trapmonster": 0x100, "spirit": 0x200, "union": 0x400, "dual": 0x800, "gemini": 0x800,
+	"tuner": 0x1000, "synchro": 0x2000, "token": 0x4000, "quickplay": 0x10000, "continuous": 0x20000,
+	"equip": 0x40000, "field": 0x80000, "counter": 0x100000, "flip": 0x200000, "toon": 0x400000,
+	"xyz": 0x800000, "pendulum": 0x1000000, "spsummon": 0x2000000, "link": 0x4000000,
+	"怪兽": 0x1, "魔法": 0x2, "陷阱": 0x4, "通常": 0x10, "效果": 0x20, "融合": 0x40,
+	"仪式": 0x80, "陷阱怪兽": 0x100, "灵魂": 0x200, "同盟": 0x400, "二重": 0x800,
+	"调整": 0x1000, "同调": 0x2000, "衍生物": 0x4000, "速攻": 0x10000, "永续": 0x20000,
+	"装备": 0x40000, "场地": 0x80000, "反击": 0x100000, "反转": 0x200000, "卡通": 0x400000,
+	"超量": 0x800000, "灵摆": 0x1000000, "特殊召唤": 0x2000000, "连接": 0x4000000,
+}
+
+const cardTypeMonster = 0x1
+const cardTypeXyz = 0x800000
+const cardTypeLink = 0x4000000
+
+var cardFilterReg, _ = regexp.Compile(`^\s*([A-Za-z]+)\s*(!=|>=|<=|==|=|>|<)\s*([^\s,]+)\s*,?`)
+
+// CardFilter selects cards by their properties. Every condition has to hold; a condition listing several
+// values with "|" holds when the card has any of them.
+type CardFilter struct {
+	Text       string
+	Conditions []CardCondition
+}
+
+type CardCondition struct {
+	Property string
+	Operator string
+	Values   []int64
+}
+
+// ParseCardFilter reads the conditions written between the braces of a property restrain,
+// such as "attribute=light race=machine level>=4".
+func ParseCardFilter(text string, environment *ygopro_data.Environment) (CardFilter, error) {
+	filter := CardFilter{Text: strings.TrimSpace(text)}
+	rest := text
+	for len(strings.TrimSpace(rest)) > 0 {
+		matches := cardFilterReg.FindStringSubmatch(rest)
+		if matches == nil {
+			return filter, fmt.Errorf("can't read card condition %v", strings.TrimSpace(rest))
+		}
+		rest = rest[len(matches[0]):]
+		condition, err := newCardCondition(strings.ToLower(matches[1]), matches[2], matches[3], environment)
+		if err != nil {
+			return filter, err
+		}
+		filter.Conditions = append(filter.Conditions, condition)
+	}
+	if len(filter.Conditions) == 0 {
+		return filter, errors.New("card filter has no condition")
+	}
+	return filter, nil
+}
+
+func newCardCondition(property, operator, text string, environment *ygopro_data.Environment) (CardCondition, error) {
+	if operator == "==" {
+		operator = "="
+	}
+	condition := CardCondition{Property: property, Operator: operator}
+	var names map[string]int64
+	switch property {
+	case "attribute":
+		names = cardAttributeValues
+	case "race":
+		names = cardRaceValues
+	case "type":
+		names = cardTypeValues
+	case "setcode":
+	case "level", "rank", "link", "atk", "def":
+		value, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return condition, fmt.Errorf("%v needs a number, not %v", property, text)
+		}
+		condition.Values = []int64{value}
+		return condition, nil
+	default:
+		return condition, fmt.Errorf("unknown card property %v", property)
+	}
+	if operator != "=" && operator != "!=" {
+		return condition, fmt.Errorf("%v can only be compared with = or !=", property)
+	}
+	for _, name := range strings.Split(text, "|") {
+		if value, err := strconv.ParseInt(name, 0, 64); err == nil {
+			condition.Values = append(condition.Values, value)
+		} else if value, ok := names[strings.ToLower(name)]; ok {
+			condition.Values = append(condition.Values, value)
+		} else if value, ok := searchSetCode(name, environment); property == "setcode" && ok {
+			condition.Values = append(condition.Values, value)
+		} else {
+			return condition, fmt.Errorf("unknown %v %v", property, name)
+		}
+	}
+	return condition, nil
+}
+
+func searchSetCode(name string, environment *ygopro_data.Environment) (int64, bool) {
+	if environment == nil {
+		return 0, false
+	}
+	for _, set := range environment.Sets {
+		if set.Name == name || set.OriginName == name {
+			return set.Code, true
+		}
+	}
+	return 0, false
+}
+
+func (filter CardFilter) Match(card ygopro_data.Card) bool {
+	for _, condition := range filter.Conditions {
+		if !condition.Match(card) {
+			return false
+		}
+	}
+	return true
+}
+
+func (condition CardCondition) Match(card ygopro_data.Card) bool {
+	switch condition.Property {
+	case "attribute":
+		return condition.matchAny(func(value int64) bool { return int64(card.Attribute)&value != 0 })
+	case "race":
+		return condition.matchAny(func(value int64) bool { return int64(card.Race)&value != 0 })
+	case "type":
+		return condition.matchAny(func(value int64) bool { return card.Type&value != 0 })
+	case "setcode":
+		return condition.matchAny(func(value int64) bool { return hasSetCode(card.Setcode, value) })
+	}
+	// Level, rank and link rating share one column, each only counts for the monsters it belongs to.
+	if card.Type&cardTypeMonster == 0 {
+		return false
+	}
+	var value int
+	switch condition.Property {
+	case "level":
+		if card.Type&(cardTypeXyz|cardTypeLink) != 0 {
+			return false
+		}
+		value = card.Level()
+	case "rank":
+		if card.Type&cardTypeXyz == 0 {
+			return false
+		}
+		value = card.Level()
+	case "link":
+		if card.Type&cardTypeLink == 0 {
+			return false
+		}
+		value = card.Level()
+	case "atk":
+		value = card.Atk
+	case "def":
+		if card.Type&cardTypeLink != 0 {
+			return false
+		}
+		value = card.Def
+	}
+	if condition.Operator == "!=" {
+		return int64(value) != condition.Values[0]
+	}
+	return NewCondition(condition.Operator, int(condition.Values[0])).Judge(value)
+}
+
+func (condition CardCondition) matchAny(has func(value int64) bool) bool {
+	any := false
+	for _, value := range condition.Values {
+		if has(value) {
+			any = true
+			break
+		}
+	}
+	if condition.Operator == "!=" {
+		return !any
+	}
+	return any
+}
+
+// hasSetCode follows the archetype rule of the game: the low 12 bits name the archetype,
+// the high 4 bits of a sub-archetype must be present as well.
+func hasSetCode(setcodes int64, code int64) bool {
+	for i := uint(0); i < 4; i++ {
+		setcode := (setcodes >> (i * 16)) & 0xffff
+		if setcode&0xfff == code&0xfff && setcode&code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSetCode reads an archetype as a number or as a set name of the strings.conf.
+func parseSetCode(text string, environment *ygopro_data.Environment) (int64, bool) {
+	if value, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return value, value > 0 && value <= 0xffff
+	}
+	return searchSetCode(text, environment)
+}
+
+// setCodeIds lists the cards of the environment in the archetype, its sub-archetypes included, in id order.
+func setCodeIds(code int64, environment *ygopro_data.Environment) []int {
+	ids := make([]int, 0)
+	for id, card := range environment.Cards {
+		if hasSetCode(card.Setcode, code) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Ids lists the cards of the environment the filter selects, in id order.
+func (filter CardFilter) Ids(environment *ygopro_data.Environment) []int {
+	ids := make([]int, 0)
+	for id, card := range environment.Cards {
+		if filter.Match(card) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -6,6 +6,9 @@ type Classification struct {
 	Name      string
 	Priority  int
 	Restrains []Restrain
+
+	// node is where the classification was defined, for diagnostics after prepare.
+	node *astNode
 }
 
 func (classification Classification) Judge(deck ygopro_data.Deck) bool {
@@ -28,22 +31,27 @@ type Deck struct {
 
 func (deckType Deck) Execute(deck ygopro_data.Deck) *Result {
 	if deckType.Judge(deck) {
-		result := new(Result)
-		result.Deck = deckType
-		for _, tag := range deckType.ForceTags {
-			result.Tags = append(result.Tags, tag)
-		}
-		for _, tag := range deckType.CheckTags {
-			if tag.Judge(deck) {
-				result.Tags = append(result.Tags, tag)
-			}
-		}
-		return result
+		return deckType.result(func(index int) bool { return deckType.CheckTags[index].Judge(deck) })
 	} else {
 		return nil
 	}
 }
 
+// result builds the answer of a matched deck type, checkTag tells whether the check tag at the index passes.
+func (deckType Deck) result(checkTag func(index int) bool) *Result {
+	result := new(Result)
+	result.Deck = deckType
+	for _, tag := range deckType.ForceTags {
+		result.Tags = append(result.Tags, tag)
+	}
+	for index, tag := range deckType.CheckTags {
+		if checkTag(index) {
+			result.Tags = append(result.Tags, tag)
+		}
+	}
+	return result
+}
+
 func (deckType Deck) RemoveRefusedTags(result *Result) []Tag {
 	if deckType.RefuseHash == nil {
 		deckType.RefuseHash = make(map[string]bool)
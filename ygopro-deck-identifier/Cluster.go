@@ -0,0 +1,322 @@
+package ygopro_deck_identifier
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"sort"
+)
+
+// Clustering groups the decks an identifier can't recognize by the cards of their main deck, to show the archetypes
+// nobody has defined yet. Every cluster comes with a draft definition meant for the preview endpoint.
+const CLUSTER_DEFAULT_THRESHOLD = 0.5
+const CLUSTER_DEFAULT_MINIMUM = 2
+
+// Cards in less than half of a cluster don't describe it.
+const CLUSTER_CARD_SHARE = 0.5
+const CLUSTER_CARD_LIMIT = 10
+
+// A set is proposed when every deck of the cluster plays at least this many of its cards.
+const CLUSTER_SET_MINIMUM = 3
+
+// Without a set, the draft asks for this many of the cards every deck of the cluster plays.
+const CLUSTER_DRAFT_CARDS = 3
+
+// ClusterCard is a card of a cluster: Share of its decks play it, at least Count copies each,
+// while Background of the other decks of the corpus do.
+type ClusterCard struct {
+	Id         int
+	Name       string
+	Share      float64
+	Background float64
+	Count      int
+}
+
+// ClusterSet is a named set every deck of the cluster plays at least Minimum cards of, Mean on average.
+type ClusterSet struct {
+	Name       string
+	Minimum    int
+	Mean       float64
+	Background float64
+}
+
+type Cluster struct {
+	Keys  []string
+	Cards []ClusterCard
+	Sets  []ClusterSet
+	// Draft is a deck definition for the cluster named DraftName; Matched counts the decks of the cluster it recognizes.
+	Draft     string
+	DraftName string
+	Matched   int
+
+	decks []*CorpusDeck
+}
+
+type ClusterReport struct {
+	Identifier string
+	Generation uint64
+	Total      int
+	Unknown    int
+	Clusters   []Cluster
+	// Diagnostics are what compiling the drafts found.
+	Diagnostics Diagnostics
+}
+
+// ClusterUnknownDecks recognizes the corpus and clusters the unknown decks: a deck joins the cluster whose
+// profile, the cards at least half of its decks play, is the most alike by Jaccard similarity, when at least
+// threshold; otherwise it starts a cluster. Clusters smaller than minimum are left out. Largest clusters come first.
+func (identifier *Identifier) ClusterUnknownDecks(corpus []CorpusDeck, threshold float64, minimum int) *ClusterReport {
+	report := &ClusterReport{Identifier: identifier.Name, Generation: identifier.Generation, Total: len(corpus), Clusters: make([]Cluster, 0)}
+	unknown := make([]*CorpusDeck, 0)
+	for index := range corpus {
+		if name, _ := identifier.recognizeNames(corpus[index].Deck); name == Config.UnknownDeck {
+			unknown = append(unknown, &corpus[index])
+		}
+	}
+	report.Unknown = len(unknown)
+
+	type grouping struct {
+		decks     []*CorpusDeck
+		frequency map[int]int
+	}
+	groups := make([]*grouping, 0)
+	for _, item := range unknown {
+		var best *grouping
+		bestSimilarity := threshold
+		for _, group := range groups {
+			if similarity := profileSimilarity(item.Deck.ClassifiedMain, group.frequency, len(group.decks)); similarity >= bestSimilarity {
+				best, bestSimilarity = group, similarity
+			}
+		}
+		if best == nil {
+			best = &grouping{frequency: make(map[int]int)}
+			groups = append(groups, best)
+		}
+		best.decks = append(best.decks, item)
+		for id := range item.Deck.ClassifiedMain {
+			best.frequency[id] += 1
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].decks) > len(groups[j].decks) })
+
+	for _, group := range groups {
+		if len(group.decks) < minimum {
+			continue
+		}
+		cluster := Cluster{decks: group.decks, Keys: make([]string, 0, len(group.decks))}
+		for _, item := range group.decks {
+			cluster.Keys = append(cluster.Keys, item.Key)
+		}
+		cluster.Cards = identifier.clusterCards(group.decks, group.frequency, corpus)
+		cluster.Sets = identifier.clusterSets(group.decks, cluster.Cards, corpus)
+		report.Clusters = append(report.Clusters, cluster)
+	}
+	identifier.draftClusters(report)
+	return report
+}
+
+// profileSimilarity is the Jaccard similarity of the cards of a deck and the profile of a cluster.
+func profileSimilarity(cards map[int]int, frequency map[int]int, size int) float64 {
+	profile := 0
+	common := 0
+	for id, count := range frequency {
+		if count*2 >= size {
+			profile += 1
+			if cards[id] > 0 {
+				common += 1
+			}
+		}
+	}
+	union := len(cards) + profile - common
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
+
+// clusterCards picks the cards telling the cluster apart from the rest of the corpus.
+func (identifier *Identifier) clusterCards(decks []*CorpusDeck, frequency map[int]int, corpus []CorpusDeck) []ClusterCard {
+	members := make(map[*CorpusDeck]bool)
+	for _, item := range decks {
+		members[item] = true
+	}
+	others := len(corpus) - len(decks)
+	cards := make([]ClusterCard, 0)
+	for id, count := range frequency {
+		share := float64(count) / float64(len(decks))
+		if share < CLUSTER_CARD_SHARE {
+			continue
+		}
+		card := ClusterCard{Id: id, Share: share, Count: 3}
+		if data, ok := identifier.BindingEnvironment.GetCard(id); ok {
+			card.Name = data.Name
+		}
+		for _, item := range decks {
+			if copies := item.Deck.ClassifiedMain[id]; copies > 0 && copies < card.Count {
+				card.Count = copies
+			}
+		}
+		if others > 0 {
+			outside := 0
+			for index := range corpus {
+				if !members[&corpus[index]] && corpus[index].Deck.ClassifiedMain[id] > 0 {
+					outside += 1
+				}
+			}
+			card.Background = float64(outside) / float64(others)
+		}
+		if card.Share > card.Background {
+			cards = append(cards, card)
+		}
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		left, right := cards[i].Share-cards[i].Background, cards[j].Share-cards[j].Background
+		if left != right {
+			return left > right
+		}
+		return cards[i].Id < cards[j].Id
+	})
+	if len(cards) > CLUSTER_CARD_LIMIT {
+		cards = cards[:CLUSTER_CARD_LIMIT]
+	}
+	return cards
+}
+
+// clusterSets looks through the named sets holding a characteristic card for those every deck of the cluster plays.
+func (identifier *Identifier) clusterSets(decks []*CorpusDeck, cards []ClusterCard, corpus []CorpusDeck) []ClusterSet {
+	characteristic := make(map[int]bool)
+	for _, card := range cards {
+		characteristic[card.Id] = true
+	}
+	candidates := make(map[string][]int)
+	for _, sets := range [][]ygopro_data.Set{identifier.BindingEnvironment.Sets, identifier.CustomSets} {
+		for _, set := range sets {
+			if len(set.Name) == 0 {
+				continue
+			}
+			for _, id := range set.Ids {
+				if characteristic[id] {
+					candidates[set.Name] = set.Ids
+					break
+				}
+			}
+		}
+	}
+	sets := make([]ClusterSet, 0)
+	for name, ids := range candidates {
+		set := ClusterSet{Name: name, Minimum: -1}
+		total := 0
+		for _, item := range decks {
+			count := countIds(item.Deck.ClassifiedMain, ids)
+			total += count
+			if set.Minimum < 0 || count < set.Minimum {
+				set.Minimum = count
+			}
+		}
+		if set.Minimum < CLUSTER_SET_MINIMUM {
+			continue
+		}
+		set.Mean = float64(total) / float64(len(decks))
+		background := 0
+		for index := range corpus {
+			background += countIds(corpus[index].Deck.ClassifiedMain, ids)
+		}
+		set.Background = float64(background-total) / float64(maxInt(len(corpus)-len(decks), 1))
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		left, right := sets[i].Mean-sets[i].Background, sets[j].Mean-sets[j].Background
+		if left != right {
+			return left > right
+		}
+		return sets[i].Name < sets[j].Name
+	})
+	return sets
+}
+
+// draftClusters writes the draft of every cluster, then compiles them together, the way they would be added,
+// to count the decks each draft takes.
+func (identifier *Identifier) draftClusters(report *ClusterReport) {
+	if len(report.Clusters) == 0 {
+		return
+	}
+	used := make(map[string]bool)
+	for _, deck := range identifier.Decks {
+		used[deck.Name] = true
+	}
+	var all bytes.Buffer
+	for index := range report.Clusters {
+		cluster := &report.Clusters[index]
+		cluster.DraftName = clusterDraftName(cluster, index, used)
+		used[cluster.DraftName] = true
+		cluster.Draft = cluster.draft()
+		all.WriteString(cluster.Draft)
+		all.WriteString("\n")
+	}
+	preview := NewIdentifier(identifier.Name + "-cluster")
+	preview.clear()
+	preview.RegisterDSL(all.String())
+	preview.Ready(identifier)
+	report.Diagnostics = preview.Diagnostics
+	for index := range report.Clusters {
+		cluster := &report.Clusters[index]
+		for _, item := range cluster.decks {
+			if name, _ := preview.recognizeNames(item.Deck); name == cluster.DraftName {
+				cluster.Matched += 1
+			}
+		}
+	}
+}
+
+// clusterDraftName names the draft after its best set or card, kept apart from the names in use.
+func clusterDraftName(cluster *Cluster, index int, used map[string]bool) string {
+	name := fmt.Sprintf("未命名%d", index+1)
+	if len(cluster.Sets) > 0 {
+		name = cluster.Sets[0].Name
+	} else if len(cluster.Cards) > 0 && len(cluster.Cards[0].Name) > 0 {
+		name = cluster.Cards[0].Name
+	}
+	base := name
+	for suffix := 2; used[name]; suffix++ {
+		name = fmt.Sprintf("%v%d", base, suffix)
+	}
+	return name
+}
+
+// draft asks for the best set, or without one for the cards every deck plays, at the least count the decks show.
+func (cluster *Cluster) draft() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("# %d decks, such as %v\n", len(cluster.Keys), cluster.Keys[0]))
+	buffer.WriteString(fmt.Sprintf("deck: %v\n", cluster.DraftName))
+	cards := CLUSTER_DRAFT_CARDS
+	if len(cluster.Sets) > 0 {
+		buffer.WriteString(fmt.Sprintf("  set: %v main >= %d\n", cluster.Sets[0].Name, cluster.Sets[0].Minimum))
+		cards = 1
+	}
+	for _, card := range cluster.Cards {
+		if cards == 0 {
+			break
+		}
+		if card.Share < 1 || len(card.Name) == 0 {
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("  card: %v main >= %d\n", card.Name, card.Count))
+		cards -= 1
+	}
+	return buffer.String()
+}
+
+func countIds(classified map[int]int, ids []int) int {
+	count := 0
+	for _, id := range ids {
+		count += classified[id]
+	}
+	return count
+}
+
+func maxInt(left int, right int) int {
+	if left > right {
+		return left
+	}
+	return right
+}
@@ -0,0 +1,307 @@
+package ygopro_deck_identifier
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"github.com/op/go-logging"
+	"os"
+	"time"
+)
+
+// Command line tools share the server configuration, but report on stdout/stderr themselves,
+// so the logger is turned down to keep their output readable.
+func quietLogging() {
+	logging.SetLevel(logging.CRITICAL, "")
+}
+
+func printDiagnostics(diagnostics Diagnostics) {
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%v:%d:%d: %v: %v\n", diagnostic.File, diagnostic.Line, diagnostic.Column, diagnostic.Severity, diagnostic.Message)
+	}
+}
+
+// FormatCommand implements `fmt [-check] [path ...]`. It rewrites definition files in canonical form and
+// lists them; with -check it only lists the files which are not formatted and fails if there are any.
+func FormatCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "only list the files which are not formatted")
+	flags.Parse(args)
+	quietLogging()
+	paths := flags.Args()
+	if len(paths) == 0 {
+		InitializeConfig()
+		paths = []string{Config.DeckDefPath}
+	}
+	files, diagnostics := FormatPaths(paths, !*check)
+	printDiagnostics(diagnostics)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	if len(diagnostics) > 0 || (*check && len(files) > 0) {
+		return 1
+	}
+	return 0
+}
+
+// LintCommand implements `lint identifier ...`. It prints what Identifier#Lint finds in each identifier
+// and fails if anything above information level is found.
+func LintCommand(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: lint identifier ...")
+		return 2
+	}
+	Initialize()
+	quietLogging()
+	status := 0
+	for _, name := range flags.Args() {
+		wrapper, ok := GlobalIdentifierMap[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Can't find Identifier named %v\n", name)
+			return 2
+		}
+		diagnostics := wrapper.Current().Lint()
+		printDiagnostics(diagnostics)
+		for _, diagnostic := range diagnostics {
+			if diagnostic.Severity != DIAGNOSTIC_INFORMATION {
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+// TestCommand implements `test [-trace=false] identifier [path ...]`. It recognizes the labeled decks of the
+// identifier's test corpus, or those under the paths, prints every failure with its verbose trace and fails
+// if any deck is not recognized as labeled.
+func TestCommand(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	trace := flags.Bool("trace", true, "print the verbose recognition of failed decks")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: test [-trace=false] identifier [path ...]")
+		return 2
+	}
+	Initialize()
+	quietLogging()
+	wrapper, ok := GlobalIdentifierMap[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Can't find Identifier named %v\n", flags.Arg(0))
+		return 2
+	}
+	var report *DeckTestReport
+	if flags.NArg() > 1 {
+		tests, diagnostics := LoadDeckTests(flags.Args()[1:])
+		report = wrapper.Current().RunDeckTests(tests)
+		report.Diagnostics = diagnostics
+	} else {
+		report = wrapper.RunTests()
+	}
+	printDiagnostics(report.Diagnostics)
+	for index := range report.Outcomes {
+		outcome := &report.Outcomes[index]
+		if outcome.Passed() {
+			continue
+		}
+		fmt.Printf("FAIL %v\n", outcome.Test.Path)
+		for _, failure := range outcome.Failures {
+			fmt.Printf("  %v:%d: %v\n", failure.File, failure.Line, failure.Message)
+		}
+		if *trace {
+			fmt.Print("  ")
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("  ", "  ")
+			encoder.Encode(outcome.Verbose)
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", len(report.Outcomes)-report.Failed, report.Failed)
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// TuneCommand implements `tune identifier deck [path ...]`. It measures every counting restrain of the deck on the
+// labeled decks of the identifier's test corpus, or those under the paths, and prints the count distributions with
+// the current and suggested conditions. Nothing is changed in the definitions.
+func TuneCommand(args []string) int {
+	flags := flag.NewFlagSet("tune", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: tune identifier deck [path ...]")
+		return 2
+	}
+	Initialize()
+	quietLogging()
+	wrapper, ok := GlobalIdentifierMap[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Can't find Identifier named %v\n", flags.Arg(0))
+		return 2
+	}
+	var tests []DeckTest
+	var diagnostics Diagnostics
+	if flags.NArg() > 2 {
+		tests, diagnostics = LoadDeckTests(flags.Args()[2:])
+	} else {
+		tests, diagnostics = wrapper.LoadTests()
+	}
+	printDiagnostics(diagnostics)
+	report, ok := wrapper.Current().TuneDeck(flags.Arg(1), tests)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Can't find deck named %v\n", flags.Arg(1))
+		return 2
+	}
+	printScore := func(title string, score TuneScore) {
+		fmt.Printf("%v precision %.3f, recall %.3f, f1 %.3f\n", title, score.Precision(), score.Recall(), score.F1())
+	}
+	fmt.Printf("# %v: %d positive, %d negative decks\n", report.Deck, report.Positive, report.Negative)
+	for _, tuned := range report.Restrains {
+		fmt.Printf("\n%v %v\n", tunePathString(tuned.Path), tuned.Target)
+		for _, value := range tuned.Distribution {
+			fmt.Printf("  %4d: %d positive, %d negative\n", value.Value, value.Positive, value.Negative)
+		}
+		printScore(fmt.Sprintf("  current   %-6v", conditionText(tuned.Current)), tuned.CurrentScore)
+		if tuned.Changed {
+			printScore(fmt.Sprintf("  suggested %-6v", conditionText(tuned.Suggested)), tuned.SuggestScore)
+		}
+	}
+	fmt.Println()
+	printScore("definition current  ", report.Current)
+	printScore("definition suggested", report.Suggested)
+	return 0
+}
+
+// CoverageCommand implements `coverage [-all] identifier [path ...]`. It runs the .ydk files under the paths, or the
+// identifier's test corpus, through the identifier and prints how often every deck, tag and restrain was judged,
+// passed and, for restrains, decided the outcome, followed by what never happened. Without -all only the lines
+// with a finding are printed.
+func CoverageCommand(args []string) int {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	all := flags.Bool("all", false, "print every definition line, not only those with a finding")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: coverage [-all] identifier [path ...]")
+		return 2
+	}
+	Initialize()
+	quietLogging()
+	wrapper, ok := GlobalIdentifierMap[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Can't find Identifier named %v\n", flags.Arg(0))
+		return 2
+	}
+	var report *CoverageReport
+	if flags.NArg() > 1 {
+		files, diagnostics := LoadDeckFiles(flags.Args()[1:])
+		printDiagnostics(diagnostics)
+		corpus := make([]CorpusDeck, 0, len(files))
+		for _, file := range files {
+			corpus = append(corpus, CorpusDeck{file.Path, file.Deck})
+		}
+		report = wrapper.Current().Coverage(corpus)
+	} else {
+		report, _ = CoverageRequest{}.Coverage(wrapper)
+	}
+	if *all {
+		for _, entry := range report.Entries {
+			decisive := ""
+			if entry.Kind == COVERAGE_RESTRAIN {
+				decisive = fmt.Sprintf(", %d decisive", entry.Decisive)
+			}
+			fmt.Printf("%v:%d: %v %v: %d judged, %d passed%v\n", entry.File, entry.Line, entry.Kind, entry.Name, entry.Evaluated, entry.Passed, decisive)
+		}
+	}
+	printDiagnostics(report.Diagnostics)
+	fmt.Printf("%d decks, %d definition nodes, %d findings\n", report.Total, len(report.Entries), len(report.Diagnostics))
+	return 0
+}
+
+// ClusterCommand implements `cluster [-threshold t] [-min n] identifier path ...`. It clusters the .ydk files under
+// the paths the identifier can't recognize and prints a draft definition for every cluster.
+func ClusterCommand(args []string) int {
+	flags := flag.NewFlagSet("cluster", flag.ExitOnError)
+	threshold := flags.Float64("threshold", CLUSTER_DEFAULT_THRESHOLD, "least Jaccard similarity of a deck to the cluster it joins")
+	minimum := flags.Int("min", CLUSTER_DEFAULT_MINIMUM, "least number of decks of a cluster")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: cluster [-threshold t] [-min n] identifier path ...")
+		return 2
+	}
+	Initialize()
+	quietLogging()
+	wrapper, ok := GlobalIdentifierMap[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Can't find Identifier named %v\n", flags.Arg(0))
+		return 2
+	}
+	files, diagnostics := LoadDeckFiles(flags.Args()[1:])
+	printDiagnostics(diagnostics)
+	corpus := make([]CorpusDeck, 0, len(files))
+	for _, file := range files {
+		corpus = append(corpus, CorpusDeck{file.Path, file.Deck})
+	}
+	report := wrapper.Current().ClusterUnknownDecks(corpus, *threshold, *minimum)
+	printDiagnostics(report.Diagnostics)
+	fmt.Printf("# %d decks, %d unknown, %d clusters\n", report.Total, report.Unknown, len(report.Clusters))
+	for _, cluster := range report.Clusters {
+		fmt.Printf("\n# recognizes %d of %d\n%v", cluster.Matched, len(cluster.Keys), cluster.Draft)
+	}
+	return 0
+}
+
+// BenchCommand implements `bench [-n count] [-rounds rounds] [-seed seed] identifier [path ...]`. It recognizes
+// the .ydk files under the paths, or random decks without paths, with and without the evaluation plan,
+// reports both timings and fails if any deck is recognized differently.
+func BenchCommand(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	count := flags.Int("n", 1000, "number of random decks when no path is given")
+	rounds := flags.Int("rounds", 10, "times every deck is recognized for the timing")
+	seed := flags.Int64("seed", 1, "seed of the random decks")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: bench [-n count] [-rounds rounds] [-seed seed] identifier [path ...]")
+		return 2
+	}
+	Initialize()
+	quietLogging()
+	wrapper, ok := GlobalIdentifierMap[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Can't find Identifier named %v\n", flags.Arg(0))
+		return 2
+	}
+	identifier := wrapper.Current()
+	var decks []ygopro_data.Deck
+	if flags.NArg() > 1 {
+		files, diagnostics := LoadDeckFiles(flags.Args()[1:])
+		printDiagnostics(diagnostics)
+		for _, file := range files {
+			decks = append(decks, file.Deck)
+		}
+	} else {
+		decks = identifier.RandomDecks(*count, *seed)
+	}
+	if len(decks) == 0 {
+		fmt.Fprintln(os.Stderr, "No deck to benchmark.")
+		return 2
+	}
+	benchmark := identifier.BenchmarkPlan(decks, *rounds)
+	recognitions := time.Duration(benchmark.Decks * benchmark.Rounds)
+	if recognitions == 0 {
+		recognitions = 1
+	}
+	fmt.Printf("decks: %d x %d rounds, %d deck types\n", benchmark.Decks, benchmark.Rounds, benchmark.DeckTypes)
+	fmt.Printf("linear:  %v (%v per deck)\n", benchmark.Linear, benchmark.Linear/recognitions)
+	fmt.Printf("indexed: %v (%v per deck), %.1f deck types judged per deck\n", benchmark.Indexed, benchmark.Indexed/recognitions, float64(benchmark.Evaluated)/float64(benchmark.Decks))
+	fmt.Printf("mismatches: %d\n", len(benchmark.Mismatches))
+	for _, mismatch := range benchmark.Mismatches {
+		fmt.Printf("  deck %d: linear [%v], indexed [%v]\n", mismatch.Index, mismatch.Linear, mismatch.Indexed)
+	}
+	if len(benchmark.Mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
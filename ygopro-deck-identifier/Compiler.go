@@ -3,9 +3,7 @@ package ygopro_deck_identifier
 import (
 	"bufio"
 	"os"
-	"path"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
@@ -21,18 +19,39 @@ var tagIdentifierReg, _ = regexp.Compile(`\((.+?)\)`)
 var priorityIdentifierReg, _ = regexp.Compile(`\[(\d+?)]`)
 var operatorReg, _ = regexp.Compile(`^\s*(\(|\)|&&|\|\||and|or|not)`)
 var restrainReg, _ = regexp.Compile(`^(.+?)(\s+?)(main|side|ex|ori|all)?(\s*?)(>|<|=)(=*)(\s*?)(\d+)`)
+
+// Card filters hold comparisons themselves, so property restrains get their own pattern, tried before restrainReg.
+var propertyRestrainReg, _ = regexp.Compile(`^(\{[^{}]*})(\s*?)(main|side|ex|ori|all)?(\s*?)(>|<|=)(=*)(\s*?)(\d+)`)
+
+// Size restrains name no card, only the range and the condition.
+var sizeRestrainReg, _ = regexp.Compile(`^(main|side|ex|ori|all)?(\s*?)(>|<|=)(=*)(\s*?)(\d+)$`)
 var tabSpaceString = strings.Repeat(" ", COMPILER_TAB_SPACE_LENGTH)
 
 type Compiler struct {
-	Root    *astNode
-	Layers  []*astNode
-	current *astNode
+	Root        *astNode
+	Layers      []*astNode
+	Diagnostics Diagnostics
+	current     *astNode
+	comments    []string
+
+	templates    map[string]*astNode
+	recording    *astNode
+	recordingTab int
+	expanding    []string
+	// pending are the uses of templates the file doesn't define, left for the templates of its imports.
+	pending  []*astNode
+	imported map[string]*astNode
 }
 
 type originMessage struct {
 	Line int
 	Text string
 	File string
+
+	// Importer is the import line which pulled the file in, nil for files registered directly.
+	Importer *originMessage
+	// ExpandedFrom is the use line a template line was compiled for, nil outside of templates.
+	ExpandedFrom *originMessage
 }
 
 type astNode struct {
@@ -40,6 +59,11 @@ type astNode struct {
 	Value    string
 	Origin   *originMessage
 	Children []*astNode
+
+	// Comments are the whole-line comments written above the node, Comment is the one trailing its line.
+	// On the root node, Comments holds the comments left at the end of the file.
+	Comments []string
+	Comment  string
 }
 
 func newAstNode(Type string, Value string) (node *astNode) {
@@ -65,11 +89,23 @@ func (compiler *Compiler) clear() {
 	compiler.Root = newAstNode("root", "")
 	compiler.Layers = append(make([]*astNode, 0), compiler.Root)
 	compiler.current = compiler.Root
+	compiler.Diagnostics = nil
+	compiler.comments = nil
+	compiler.templates = make(map[string]*astNode)
+	compiler.recording = nil
+	compiler.expanding = nil
+	compiler.pending = nil
+	compiler.imported = nil
 }
 
 func (compiler *Compiler) CompileFile(filename string) {
 	compiler.clear()
-	file, _ := os.Open(filename)
+	file, err := os.Open(filename)
+	if err != nil {
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "file-unreadable", nil, newOriginMessage(0, "", filename), "Failed to open deck definition file: %v", err)
+		return
+	}
+	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	lineNumber := 1
 	for scanner.Scan() {
@@ -77,21 +113,44 @@ func (compiler *Compiler) CompileFile(filename string) {
 		compiler.compileLine(line, newOriginMessage(lineNumber, line, filename))
 		lineNumber += 1
 	}
+	compiler.finish()
 }
 
 func (compiler *Compiler) CompileString(string string) {
+	compiler.CompileNamedString(string, "anonymous")
+}
+
+// CompileNamedString compiles an in-memory definition as if it was read from filename.
+func (compiler *Compiler) CompileNamedString(string string, filename string) {
 	compiler.clear()
 	for lineNumber, line := range strings.Split(string, "\n") {
-		compiler.compileLine(line, newOriginMessage(lineNumber, line, "anonymous"))
+		line = strings.TrimSuffix(line, "\r")
+		compiler.compileLine(line, newOriginMessage(lineNumber+1, line, filename))
 	}
+	compiler.finish()
+}
+
+func (compiler *Compiler) finish() {
+	compiler.finishTemplate()
+	compiler.Root.Comments = compiler.comments
+	compiler.comments = nil
 }
 
 func (compiler *Compiler) compileLine(line string, message *originMessage) *astNode {
-	if len(line) == 0 || strings.HasPrefix(line, COMPILER_COMMENT_CHARACTER) {
+	if compiler.recordTemplateLine(line, message) {
+		return nil
+	}
+	comment := compiler.removeLineComment(&line)
+	if len(strings.TrimSpace(line)) == 0 && len(comment) > 0 {
+		compiler.comments = append(compiler.comments, comment)
+	}
+	if len(line) == 0 {
 		return nil
 	}
-	compiler.removeLineComment(&line)
 	tab := compiler.measureLineStrip(line)
+	if (tab-1)%COMPILER_TAB_SPACE_LENGTH != 0 && len(strings.TrimSpace(line)) > 0 {
+		compiler.Diagnostics.report(DIAGNOSTIC_WARNING, "bad-indentation", nil, message, "Indentation of %d spaces is not a multiple of %d.", tab-1, COMPILER_TAB_SPACE_LENGTH)
+	}
 	compiler.adjustLaysAndFocus(tab)
 	line = strings.TrimSpace(line)
 	if len(line) == 0 {
@@ -105,17 +164,32 @@ func (compiler *Compiler) compileLine(line string, message *originMessage) *astN
 		}
 		compiler.Layers[tab] = node
 		compiler.current.Children = append(compiler.current.Children, node)
-		node.Origin = message
+		node.setOrigin(message)
+		node.Comments = compiler.comments
+		node.Comment = comment
+		compiler.comments = nil
+		switch node.Type {
+		case "template":
+			compiler.recording = node
+			compiler.recordingTab = tab
+		case "use":
+			compiler.useTemplate(node)
+		}
 		return node
 	}
 	return nil
 }
 
-func (compiler *Compiler) removeLineComment(line *string) {
+// removeLineComment cuts the comment off the line and returns it, including the comment character.
+func (compiler *Compiler) removeLineComment(line *string) string {
 	// FIXME: remove MAGIC character transform.
 	middlewareLine := strings.Replace(*line, "\\"+COMPILER_COMMENT_CHARACTER, "^$^", -1)
-	part := strings.Split(middlewareLine, COMPILER_COMMENT_CHARACTER)[0]
-	*line = strings.Replace(part, "^$^", COMPILER_COMMENT_CHARACTER, -1)
+	parts := strings.SplitN(middlewareLine, COMPILER_COMMENT_CHARACTER, 2)
+	*line = strings.Replace(parts[0], "^$^", COMPILER_COMMENT_CHARACTER, -1)
+	if len(parts) == 1 {
+		return ""
+	}
+	return strings.TrimSpace(COMPILER_COMMENT_CHARACTER + strings.Replace(parts[1], "^$^", "\\"+COMPILER_COMMENT_CHARACTER, -1))
 }
 
 func (compiler *Compiler) measureLineStrip(line string) int {
@@ -173,6 +247,12 @@ func (compiler *Compiler) compileLineContent(line string, message *originMessage
 		node = compiler.generateRestrainsNode(line, "")
 	case "card":
 		node = compiler.generateRestrainNode(line, "card")
+	case "property", "filter":
+		node = compiler.generateRestrainNode(line, "property")
+	case "size", "distinct":
+		node = compiler.generateSizeNode(line, lineType)
+	case "compare", "ratio":
+		node = compiler.generateCompareNode(line)
 	case "set", "series":
 		if compiler.current == compiler.Root {
 			node = newAstNode("set", strings.TrimSpace(line))
@@ -181,11 +261,21 @@ func (compiler *Compiler) compileLineContent(line string, message *originMessage
 		}
 	case "restrain", COMPILER_RESTRAIN_IDENTIFIER:
 		node = compiler.parseTokens(compiler.generateTokens(line))
-		node.setOrigin(message)
+		if node != nil {
+			node.setOrigin(message)
+		}
 	case "set card":
 		node = newAstNode("set card", strings.TrimSpace(line))
 	case "inner set":
 		node = newAstNode("inner set", strings.TrimSpace(line))
+	case "setcode", "set code", "archetype":
+		node = newAstNode("set code", strings.TrimSpace(line))
+	case "template":
+		node = compiler.generateTemplateNode(line, message)
+	case "use":
+		node = newAstNode("use", strings.TrimSpace(line))
+	case "import", "include":
+		node = newAstNode("import", strings.TrimSpace(line))
 	case "priority":
 		node = newAstNode("priority", strings.TrimSpace(line))
 	case "config":
@@ -194,7 +284,7 @@ func (compiler *Compiler) compileLineContent(line string, message *originMessage
 		node = nil
 	}
 	if node == nil {
-		Logger.Warning("[" + path.Base(message.File) + "] L" + strconv.Itoa(message.Line) + " Can't parse " + lineType + " line: " + line)
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "unparsable-line", nil, message, "Can't parse %v line: %v", lineType, line)
 	}
 	return node
 }
@@ -216,6 +306,9 @@ func (compiler *Compiler) guessLineType(linePointer *string) string {
 	if strings.HasPrefix(line, COMPILER_RESTRAIN_IDENTIFIER) {
 		*linePointer = line[len(COMPILER_RESTRAIN_IDENTIFIER):]
 		return "restrain"
+	} else if match := propertyRestrainReg.FindString(line); len(match) > 0 {
+		*linePointer = match
+		return "property"
 	} else if match := restrainReg.FindString(line); len(match) > 0 {
 		*linePointer = match
 		return "retrain"
@@ -279,12 +372,17 @@ func (compiler *Compiler) separateClassificationPriority(classificationName *str
 }
 
 func (compiler *Compiler) generateRestrainNode(line, class string) *astNode {
-	matches := restrainReg.FindStringSubmatch(line)
-	if len(matches) == 0 {
+	line = strings.TrimSpace(line)
+	matches := propertyRestrainReg.FindStringSubmatch(line)
+	if len(matches) > 0 && (class == "" || class == "property") {
+		class = "property"
+	} else if matches = restrainReg.FindStringSubmatch(line); len(matches) == 0 {
 		return nil
 	}
 	name := matches[1]
-	if len(class) == 0 {
+	if class == "property" {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "{"), "}")
+	} else if len(class) == 0 {
 		class = compiler.guessRestrainType(&name)
 	}
 	field := matches[3]
@@ -298,6 +396,33 @@ func (compiler *Compiler) generateRestrainNode(line, class string) *astNode {
 	return node
 }
 
+func (compiler *Compiler) generateSizeNode(line, class string) *astNode {
+	matches := sizeRestrainReg.FindStringSubmatch(strings.TrimSpace(line))
+	if len(matches) == 0 {
+		return nil
+	}
+	field := matches[1]
+	if len(field) == 0 {
+		field = "all"
+	}
+	node := newAstNode("restrain", class)
+	node.Children = append(node.Children, newAstNode("range", field))
+	node.Children = append(node.Children, newAstNode("condition", strings.Join(matches[3:7], "")))
+	return node
+}
+
+func (compiler *Compiler) generateCompareNode(line string) *astNode {
+	left, operator, right, ok := splitComparison(line)
+	if !ok || len(left) == 0 || len(right) == 0 {
+		return nil
+	}
+	node := newAstNode("restrain", "compare")
+	node.Children = append(node.Children, newAstNode("left", left))
+	node.Children = append(node.Children, newAstNode("condition", operator))
+	node.Children = append(node.Children, newAstNode("right", right))
+	return node
+}
+
 func (compiler *Compiler) generateRestrainsNode(line, class string) *astNode {
 	if len(class) == 0 {
 		class = strings.ToLower(line)
@@ -328,6 +453,9 @@ func (compiler *Compiler) generateTokens(line string) []*astNode {
 		if match := operatorReg.FindString(line); len(match) > 0 {
 			nodes = append(nodes, newAstNode("operator", strings.TrimSpace(match)))
 			line = line[len(match):]
+		} else if match = propertyRestrainReg.FindString(line); len(match) > 0 {
+			nodes = append(nodes, compiler.generateRestrainNode(match, "property"))
+			line = line[len(match):]
 		} else if match = restrainReg.FindString(line); len(match) > 0 {
 			nodes = append(nodes, compiler.generateRestrainNode(match, ""))
 			line = line[len(match):]
@@ -339,6 +467,9 @@ func (compiler *Compiler) generateTokens(line string) []*astNode {
 }
 
 func (compiler *Compiler) parseTokens(nodes []*astNode) *astNode {
+	if len(nodes) == 0 {
+		return nil
+	}
 	index := 0
 	return compiler.parseTokensInLevel(nodes, 3, &index)
 }
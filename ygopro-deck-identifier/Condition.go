@@ -53,3 +53,27 @@ func (condition Condition) Judge(value int) bool {
 func (condition Condition) String() string {
 	return fmt.Sprintf("Condition [%v %v]", condition.operator, condition.number)
 }
+
+// Margin tells how far the value is inside the passing range of the condition: it is >= 0 exactly
+// when Judge passes, and negative by the distance to the nearest passing value otherwise.
+func (condition Condition) Margin(value int) int {
+	switch condition.operator {
+	case ">":
+		return value - condition.number - 1
+	case "<":
+		return condition.number - 1 - value
+	case ">=":
+		return value - condition.number
+	case "<=":
+		return condition.number - value
+	case "=", "==", "&", "&&", "and":
+		if value > condition.number {
+			return condition.number - value
+		}
+		return value - condition.number
+	case "|", "||", "or":
+		return value - 1
+	default:
+		return -1
+	}
+}
@@ -6,8 +6,12 @@ import (
 )
 
 type Configuration struct {
-	DatabasePath    string
-	DeckDefPath     string
+	DatabasePath string
+	DeckDefPath  string
+	// LibraryPath holds the definitions shared through import lines, DeckDefPath when left empty.
+	LibraryPath string
+	// RecordPath is the SQLite database recognitions are recorded in, recording is off when left empty.
+	RecordPath      string
 	UnknownDeck     string
 	IdentifierNames []string
 	Listening       string
@@ -0,0 +1,233 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"sort"
+	"strings"
+)
+
+// Coverage recognizes a corpus the way Recognize does and counts, for every deck, tag and restrain of the
+// definitions, how many decks judged it and how many passed it. Decks are judged in order until one matches,
+// the check tags of the matched deck and every global tag after. A judged deck or tag answers all of its
+// restrains, and a restrain is decisive on a deck when flipping its answer alone would flip whether its deck
+// or tag matches. Restrains judged but never decisive don't change anything on the corpus.
+const COVERAGE_DECK = "deck"
+const COVERAGE_TAG = "tag"
+const COVERAGE_RESTRAIN = "restrain"
+
+// CoverageEntry is one node of the definitions. Owner is the deck or tag a restrain belongs to.
+type CoverageEntry struct {
+	Kind      string
+	Name      string
+	Owner     string
+	File      string
+	Line      int
+	Text      string
+	Evaluated int
+	Passed    int
+	Decisive  int
+
+	node  *astNode
+	order int
+}
+
+type CoverageReport struct {
+	Identifier string
+	Generation uint64
+	Total      int
+	// Entries come by file and line.
+	Entries []CoverageEntry
+	// Diagnostics point at the decks and tags never matched and the restrains never decisive.
+	Diagnostics Diagnostics
+}
+
+type coverageRun struct {
+	entries map[*astNode]*CoverageEntry
+}
+
+// Coverage runs the corpus through this version of the identifier.
+func (identifier *Identifier) Coverage(corpus []CorpusDeck) *CoverageReport {
+	run := &coverageRun{entries: make(map[*astNode]*CoverageEntry)}
+	for _, deckType := range identifier.Decks {
+		run.declare(COVERAGE_DECK, deckType.Name, deckType.node, deckType.Restrains)
+		for _, tag := range deckType.CheckTags {
+			run.declare(COVERAGE_TAG, tag.Name, tag.node, tag.Restrains)
+		}
+	}
+	for _, tag := range identifier.GlobalTags {
+		run.declare(COVERAGE_TAG, tag.Name, tag.node, tag.Restrains)
+	}
+
+	for index := range corpus {
+		deck := &corpus[index].Deck
+		for _, deckType := range identifier.Decks {
+			if !run.judge(deckType.node, deckType.Classification, deck) {
+				continue
+			}
+			for _, tag := range deckType.CheckTags {
+				run.judge(tag.node, tag.Classification, deck)
+			}
+			break
+		}
+		for _, tag := range identifier.GlobalTags {
+			run.judge(tag.node, tag.Classification, deck)
+		}
+	}
+
+	report := &CoverageReport{Identifier: identifier.Name, Generation: identifier.Generation, Total: len(corpus), Entries: make([]CoverageEntry, 0, len(run.entries)), Diagnostics: make(Diagnostics, 0)}
+	for _, entry := range run.entries {
+		report.Entries = append(report.Entries, *entry)
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		left, right := &report.Entries[i], &report.Entries[j]
+		if left.File != right.File {
+			return left.File < right.File
+		}
+		if left.Line != right.Line {
+			return left.Line < right.Line
+		}
+		return left.order < right.order
+	})
+	for index := range report.Entries {
+		report.Entries[index].review(&report.Diagnostics)
+	}
+	return report
+}
+
+// declare creates the entries of a deck or tag and its restrains, so the ones never judged are reported as well.
+func (run *coverageRun) declare(kind string, name string, node *astNode, restrains []Restrain) {
+	if node == nil {
+		return
+	}
+	if _, ok := run.entries[node]; ok {
+		return
+	}
+	run.add(kind, name, "", node)
+	run.declareRestrains(name, restrains, restrainNodes(node))
+}
+
+func (run *coverageRun) declareRestrains(owner string, restrains []Restrain, nodes []*astNode) {
+	for index, restrain := range restrains {
+		if index >= len(nodes) {
+			return
+		}
+		run.add(COVERAGE_RESTRAIN, restrain.Type(), owner, nodes[index])
+		if group, ok := restrain.(RestrainGroup); ok {
+			run.declareRestrains(owner, group.Restrains, restrainNodes(nodes[index]))
+		}
+	}
+}
+
+func (run *coverageRun) add(kind string, name string, owner string, node *astNode) {
+	entry := &CoverageEntry{Kind: kind, Name: name, Owner: owner, node: node, order: len(run.entries)}
+	if node.Origin != nil {
+		entry.File, entry.Line, entry.Text = node.Origin.File, node.Origin.Line, strings.TrimSpace(node.Origin.Text)
+	}
+	run.entries[node] = entry
+}
+
+// judge answers the classification on the deck, counting every node on the way, and tells whether it matched.
+func (run *coverageRun) judge(node *astNode, classification Classification, deck *ygopro_data.Deck) bool {
+	is, answers := classification.verboseJudge(deck)
+	is = is && len(answers) > 0
+	if entry, ok := run.entries[node]; ok {
+		entry.Evaluated += 1
+		if is {
+			entry.Passed += 1
+		}
+	}
+	nodes := restrainNodes(node)
+	for index, answer := range answers {
+		others := true
+		for other := range answers {
+			if other != index && !answers[other].is {
+				others = false
+				break
+			}
+		}
+		if index < len(nodes) {
+			run.count(answer, nodes[index], others)
+		}
+	}
+	return is
+}
+
+// count records one restrain answer, decisive telling whether flipping it would flip its deck or tag.
+func (run *coverageRun) count(answer VerboseRestrainAnswer, node *astNode, decisive bool) {
+	if entry, ok := run.entries[node]; ok {
+		entry.Evaluated += 1
+		if answer.is {
+			entry.Passed += 1
+		}
+		if decisive {
+			entry.Decisive += 1
+		}
+	}
+	group, ok := answer.restrain.(RestrainGroup)
+	if !ok {
+		return
+	}
+	nodes := restrainNodes(node)
+	for index, child := range answer.children {
+		if index >= len(nodes) {
+			break
+		}
+		flipped := answer.value + 1
+		if child.is {
+			flipped = answer.value - 1
+		}
+		run.count(child, nodes[index], decisive && group.Condition.Judge(flipped) != answer.is)
+	}
+}
+
+// review reports what the entry shows never happened on the corpus. Definitions imported from a library are
+// left alone, like Lint does.
+func (entry *CoverageEntry) review(diagnostics *Diagnostics) {
+	if entry.node.Origin != nil && entry.node.Origin.Importer != nil {
+		return
+	}
+	switch {
+	case entry.Kind != COVERAGE_RESTRAIN && entry.Evaluated == 0:
+		diagnostics.report(DIAGNOSTIC_INFORMATION, "uncovered-"+entry.Kind, entry.node, nil, "%v %v is never judged, the decks before it take the whole corpus", strings.Title(entry.Kind), entry.Name)
+	case entry.Kind != COVERAGE_RESTRAIN && entry.Passed == 0:
+		diagnostics.report(DIAGNOSTIC_INFORMATION, "unmatched-"+entry.Kind, entry.node, nil, "%v %v never matches, judged on %d decks", strings.Title(entry.Kind), entry.Name, entry.Evaluated)
+	case entry.Kind == COVERAGE_RESTRAIN && entry.Evaluated > 0 && entry.Decisive == 0:
+		diagnostics.report(DIAGNOSTIC_INFORMATION, "redundant-restrain", entry.node, nil, "%v restrain of %v never changes whether it matches, judged on %d decks and passed on %d", entry.Name, entry.Owner, entry.Evaluated, entry.Passed)
+	}
+}
+
+// restrainNodes lists the restrain children of a node, in the order their restrains were created.
+func restrainNodes(node *astNode) []*astNode {
+	nodes := make([]*astNode, 0)
+	if node == nil {
+		return nodes
+	}
+	for _, child := range node.Children {
+		if child.Type == "restrain" {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// CoverageRequest runs a corpus through an identifier; without decks the identifier's test corpus is used.
+type CoverageRequest struct {
+	Decks    []BatchDeck `json:"decks"`
+	Separate bool        `json:"separate"`
+}
+
+func (request CoverageRequest) Coverage(identifier *IdentifierWrapper) (*CoverageReport, map[string]string) {
+	var corpus []CorpusDeck
+	errors := make(map[string]string)
+	var diagnostics Diagnostics
+	if request.Decks != nil {
+		corpus, errors = LoadCorpus(request.Decks, request.Separate)
+	} else {
+		var tests []DeckTest
+		tests, diagnostics = identifier.LoadTests()
+		corpus = DeckTestCorpus(tests)
+	}
+	report := identifier.Current().Coverage(corpus)
+	report.Diagnostics = append(diagnostics, report.Diagnostics...)
+	return report, errors
+}
@@ -0,0 +1,177 @@
+package ygopro_deck_identifier
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Deck codes are small, anything inflating past this is not a deck.
+const DECK_CODE_MAX_SIZE = 64 << 10
+
+// DeckDecoder reads one textual deck format. Detect only has to be cheap enough to be tried on every deck;
+// decoders are asked in registration order and the first one detecting the text decodes it.
+type DeckDecoder interface {
+	Name() string
+	Detect(text string) bool
+	Decode(text string) (ygopro_data.Deck, error)
+}
+
+var deckDecoders = make([]DeckDecoder, 0)
+
+// RegisterDeckDecoder adds a decoder in front of the .ydk fallback, after the decoders registered before it.
+func RegisterDeckDecoder(decoder DeckDecoder) {
+	deckDecoders = append(deckDecoders, decoder)
+}
+
+func init() {
+	RegisterDeckDecoder(ydkeDecoder{})
+	RegisterDeckDecoder(jsonDeckDecoder{})
+	RegisterDeckDecoder(deckCodeDecoder{})
+}
+
+// DecodeDeck reads a deck in any registered format, falling back to .ydk text, and tells which format it was.
+func DecodeDeck(text string) (ygopro_data.Deck, string, error) {
+	text = strings.TrimSpace(text)
+	for _, decoder := range deckDecoders {
+		if decoder.Detect(text) {
+			deck, err := decoder.Decode(text)
+			return deck, decoder.Name(), err
+		}
+	}
+	return ygopro_data.LoadYdkFromString(text), "ydk", nil
+}
+
+// ======================
+// ydke://main!extra!side!
+// ======================
+type ydkeDecoder struct{}
+
+func (ydkeDecoder) Name() string {
+	return "ydke"
+}
+
+func (ydkeDecoder) Detect(text string) bool {
+	return strings.HasPrefix(text, "ydke://")
+}
+
+func (ydkeDecoder) Decode(text string) (ygopro_data.Deck, error) {
+	deck := ygopro_data.Deck{}
+	// A "+" of the base64 turns into a space when the url went through a query string.
+	parts := strings.Split(strings.Replace(strings.TrimPrefix(text, "ydke://"), " ", "+", -1), "!")
+	if len(parts) < 3 {
+		return deck, errors.New("ydke url needs main, extra and side parts")
+	}
+	packs := []*[]int{&deck.Main, &deck.Ex, &deck.Side}
+	for index, pack := range packs {
+		data, err := base64.StdEncoding.DecodeString(parts[index])
+		if err != nil {
+			return deck, fmt.Errorf("bad base64 in ydke url: %v", err)
+		}
+		if len(data)%4 != 0 {
+			return deck, errors.New("ydke url part is not a list of passcodes")
+		}
+		for i := 0; i < len(data); i += 4 {
+			*pack = append(*pack, int(binary.LittleEndian.Uint32(data[i:])))
+		}
+	}
+	return deck, nil
+}
+
+// ======================
+// {"main": [], "extra": [], "side": []}
+// ======================
+type jsonDeckDecoder struct{}
+
+func (jsonDeckDecoder) Name() string {
+	return "json"
+}
+
+func (jsonDeckDecoder) Detect(text string) bool {
+	return strings.HasPrefix(text, "{")
+}
+
+func (jsonDeckDecoder) Decode(text string) (ygopro_data.Deck, error) {
+	var lists struct {
+		Main  []int `json:"main"`
+		Extra []int `json:"extra"`
+		Side  []int `json:"side"`
+	}
+	if err := json.Unmarshal([]byte(text), &lists); err != nil {
+		return ygopro_data.Deck{}, fmt.Errorf("bad json deck: %v", err)
+	}
+	return ygopro_data.Deck{Main: lists.Main, Ex: lists.Extra, Side: lists.Side}, nil
+}
+
+// ======================
+// Deck codes: base64 of the passcodes behind their counts. YGOPro writes the counts as two int32,
+// Omega deflates the data and writes them as two bytes. Both keep the extra deck inside main.
+// ======================
+type deckCodeDecoder struct{}
+
+func (deckCodeDecoder) Name() string {
+	return "code"
+}
+
+func (deckCodeDecoder) Detect(text string) bool {
+	if len(text) == 0 || strings.ContainsAny(text, " \t\r\n#!") {
+		return false
+	}
+	_, ok := decodeDeckCode(text)
+	return ok
+}
+
+func (deckCodeDecoder) Decode(text string) (ygopro_data.Deck, error) {
+	deck, ok := decodeDeckCode(text)
+	if !ok {
+		return deck, errors.New("bad deck code")
+	}
+	deck.SeparateExFromMainFromCache(ygopro_data.GetEnvironment("zh-CN"))
+	return deck, nil
+}
+
+func decodeDeckCode(text string) (ygopro_data.Deck, bool) {
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(text); err != nil {
+			return ygopro_data.Deck{}, false
+		}
+	}
+	if len(data) >= 8 {
+		main := int(binary.LittleEndian.Uint32(data[0:4]))
+		side := int(binary.LittleEndian.Uint32(data[4:8]))
+		if main >= 0 && side >= 0 && main+side <= DECK_CODE_MAX_SIZE && 8+4*(main+side) == len(data) {
+			return readDeckCodePacks(data[8:], main), true
+		}
+	}
+	inflated, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), DECK_CODE_MAX_SIZE))
+	if err != nil || len(inflated) < 2 {
+		return ygopro_data.Deck{}, false
+	}
+	main, side := int(inflated[0]), int(inflated[1])
+	if 2+4*(main+side) != len(inflated) {
+		return ygopro_data.Deck{}, false
+	}
+	return readDeckCodePacks(inflated[2:], main), true
+}
+
+func readDeckCodePacks(data []byte, main int) ygopro_data.Deck {
+	deck := ygopro_data.Deck{}
+	for i := 0; i+4 <= len(data); i += 4 {
+		id := int(binary.LittleEndian.Uint32(data[i:]))
+		if i/4 < main {
+			deck.Main = append(deck.Main, id)
+		} else {
+			deck.Side = append(deck.Side, id)
+		}
+	}
+	return deck
+}
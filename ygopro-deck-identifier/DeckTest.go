@@ -0,0 +1,207 @@
+package ygopro_deck_identifier
+
+import (
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"os"
+	"path"
+	"strings"
+)
+
+// The test corpus of an identifier lives in the tests directory beside its definitions. Every .ydk file in it
+// is a deck labeled with what it must be recognized as, in comments the ydk reader skips:
+//
+//	#expect deck: 影依
+//	#expect tag: 闪刀
+//	#expect no tag: 混合
+//
+// The deck is compared with the name the recognition answers, affixes included, so an unknown deck expects
+// the configured UnknownDeck. Tags may be listed several times, each one separated by commas.
+const DECK_TEST_DIRECTORY = "tests"
+
+const deckTestPrefix = "#expect"
+
+// DeckTest is a labeled deck of the corpus. Line numbers point to the expectations, for the reports.
+type DeckTest struct {
+	Path        string
+	Deck        ygopro_data.Deck
+	ExpectDeck  string
+	ExpectTags  []string
+	RefuseTags  []string
+	deckLine    int
+	tagLines    map[string]int
+	refuseLines map[string]int
+}
+
+// DeckTestOutcome is the recognition of one test deck; Verbose holds the trace when it failed.
+type DeckTestOutcome struct {
+	Test     *DeckTest
+	Deck     string
+	Tags     []string
+	Failures Diagnostics
+	Verbose  map[string]interface{}
+}
+
+func (outcome *DeckTestOutcome) Passed() bool {
+	return len(outcome.Failures) == 0
+}
+
+type DeckTestReport struct {
+	Identifier string
+	Generation uint64
+	Outcomes   []DeckTestOutcome
+	Failed     int
+	// Diagnostics are the files which couldn't be read or carry no expectation.
+	Diagnostics Diagnostics
+}
+
+// LoadDeckTests reads the labeled decks under the paths. Files without any expectation are reported and left out.
+func LoadDeckTests(paths []string) ([]DeckTest, Diagnostics) {
+	tests := make([]DeckTest, 0)
+	labels := make(Diagnostics, 0)
+	diagnostics := walkDeckFiles(paths, func(path string, content string) {
+		if test, ok := parseDeckTest(path, content, &labels); ok {
+			tests = append(tests, test)
+		}
+	})
+	return tests, append(diagnostics, labels...)
+}
+
+func parseDeckTest(path string, content string, diagnostics *Diagnostics) (DeckTest, bool) {
+	test := DeckTest{Path: path, ExpectTags: make([]string, 0), RefuseTags: make([]string, 0), tagLines: make(map[string]int), refuseLines: make(map[string]int)}
+	labeled := false
+	for index, line := range strings.Split(strings.Replace(content, "\r", "", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, deckTestPrefix) {
+			continue
+		}
+		key, value, ok := splitDeckTestLine(strings.TrimSpace(line[len(deckTestPrefix):]))
+		if !ok {
+			diagnostics.report(DIAGNOSTIC_WARNING, "bad-expectation", nil, newOriginMessage(index+1, line, path), "Can't read the expectation %v", line)
+			continue
+		}
+		labeled = true
+		switch key {
+		case "deck":
+			test.ExpectDeck = value
+			test.deckLine = index + 1
+		case "tag", "tags":
+			for _, name := range splitDeckTestNames(value) {
+				test.ExpectTags = append(test.ExpectTags, name)
+				test.tagLines[name] = index + 1
+			}
+		case "no tag", "no tags":
+			for _, name := range splitDeckTestNames(value) {
+				test.RefuseTags = append(test.RefuseTags, name)
+				test.refuseLines[name] = index + 1
+			}
+		default:
+			diagnostics.report(DIAGNOSTIC_WARNING, "bad-expectation", nil, newOriginMessage(index+1, line, path), "Unknown expectation %v", key)
+		}
+	}
+	if !labeled {
+		diagnostics.report(DIAGNOSTIC_WARNING, "unlabeled-test", nil, newOriginMessage(0, "", path), "Test deck %v expects nothing and is skipped", path)
+		return test, false
+	}
+	test.Deck = PrepareDeck(ygopro_data.LoadYdkFromString(content), false)
+	return test, true
+}
+
+func splitDeckTestLine(text string) (string, string, bool) {
+	index := strings.Index(text, ":")
+	if index < 0 {
+		return "", "", false
+	}
+	key := strings.Join(strings.Fields(strings.ToLower(text[:index])), " ")
+	value := strings.TrimSpace(text[index+1:])
+	return key, value, len(key) > 0 && len(value) > 0
+}
+
+func splitDeckTestNames(text string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(text, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RunDeckTests recognizes every test deck on this version of the identifier.
+func (identifier *Identifier) RunDeckTests(tests []DeckTest) *DeckTestReport {
+	report := &DeckTestReport{Identifier: identifier.Name, Generation: identifier.Generation, Outcomes: make([]DeckTestOutcome, 0, len(tests))}
+	for index := range tests {
+		outcome := identifier.runDeckTest(&tests[index])
+		if !outcome.Passed() {
+			report.Failed += 1
+		}
+		report.Outcomes = append(report.Outcomes, outcome)
+	}
+	return report
+}
+
+func (identifier *Identifier) runDeckTest(test *DeckTest) DeckTestOutcome {
+	outcome := DeckTestOutcome{Test: test, Failures: make(Diagnostics, 0)}
+	outcome.Deck, outcome.Tags = identifier.recognizeNames(test.Deck)
+	tags := make(map[string]bool)
+	for _, name := range outcome.Tags {
+		tags[name] = true
+	}
+	fail := func(line int, format string, args ...interface{}) {
+		outcome.Failures = append(outcome.Failures, newDiagnostic(DIAGNOSTIC_ERROR, "test-failed", nil, newOriginMessage(line, "", test.Path), fmt.Sprintf(format, args...)))
+	}
+	if len(test.ExpectDeck) > 0 && test.ExpectDeck != outcome.Deck {
+		fail(test.deckLine, "Expected deck %v, recognized as %v", test.ExpectDeck, outcome.Deck)
+	}
+	for _, name := range test.ExpectTags {
+		if !tags[name] {
+			fail(test.tagLines[name], "Expected tag %v, got [%v]", name, strings.Join(outcome.Tags, ", "))
+		}
+	}
+	for _, name := range test.RefuseTags {
+		if tags[name] {
+			fail(test.refuseLines[name], "Tag %v should not be given", name)
+		}
+	}
+	if !outcome.Passed() {
+		outcome.Verbose = identifier.VerboseRecognizeAsJson(test.Deck)
+	}
+	return outcome
+}
+
+// recognizeNames answers the deck name and tag names the way the recognition API does, affixes applied,
+// without counting in the metrics.
+func (identifier *Identifier) recognizeNames(deck ygopro_data.Deck) (string, []string) {
+	json := identifier.recognitionJson(deck)
+	name, _ := json["deck"].(string)
+	tags := make([]string, 0)
+	if names, ok := json["tag"].([]string); ok {
+		for _, tag := range names {
+			if len(tag) > 0 {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return name, tags
+}
+
+func (identifier *IdentifierWrapper) GetTestPath() string {
+	return path.Join(identifier.GetPath(), DECK_TEST_DIRECTORY)
+}
+
+// LoadTests reads the corpus of the identifier, which is empty without a tests directory.
+func (identifier *IdentifierWrapper) LoadTests() ([]DeckTest, Diagnostics) {
+	testPath := identifier.GetTestPath()
+	if _, err := os.Stat(testPath); err != nil {
+		return nil, nil
+	}
+	return LoadDeckTests([]string{testPath})
+}
+
+// RunTests runs the corpus of the identifier on its current version.
+func (identifier *IdentifierWrapper) RunTests() *DeckTestReport {
+	tests, diagnostics := identifier.LoadTests()
+	report := identifier.Current().RunDeckTests(tests)
+	report.Diagnostics = diagnostics
+	return report
+}
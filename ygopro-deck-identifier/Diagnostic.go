@@ -0,0 +1,108 @@
+package ygopro_deck_identifier
+
+import (
+	"fmt"
+	"github.com/op/go-logging"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const DIAGNOSTIC_ERROR = "error"
+const DIAGNOSTIC_WARNING = "warning"
+const DIAGNOSTIC_INFORMATION = "information"
+
+// diagnosticLogger skips the report frame, so the log still names the function that found the problem.
+var diagnosticLogger = newDiagnosticLogger()
+
+func newDiagnosticLogger() *logging.Logger {
+	logger := logging.MustGetLogger("standard")
+	logger.ExtraCalldepth = 1
+	return logger
+}
+
+// Diagnostic is a single problem found while compiling or preparing deck definitions.
+// Line is 1-based, Column and EndColumn are 1-based character offsets in the source line,
+// EndColumn is exclusive.
+type Diagnostic struct {
+	Severity  string
+	Code      string
+	File      string
+	Line      int
+	Column    int
+	EndColumn int
+	Message   string
+	Node      *astNode
+
+	// Importer is the import line which brought the file in, when it didn't belong to the identifier itself.
+	Importer *originMessage
+	// ExpandedFrom is the template use the line was compiled for.
+	ExpandedFrom *originMessage
+}
+
+type Diagnostics []Diagnostic
+
+func newDiagnostic(severity, code string, node *astNode, origin *originMessage, message string) Diagnostic {
+	diagnostic := Diagnostic{Severity: severity, Code: code, Message: message, Node: node}
+	if origin == nil && node != nil {
+		origin = node.Origin
+	}
+	if origin != nil {
+		diagnostic.File = origin.File
+		diagnostic.Line = origin.Line
+		diagnostic.Importer = origin.Importer
+		diagnostic.ExpandedFrom = origin.ExpandedFrom
+		value := ""
+		if node != nil {
+			value = node.Value
+		}
+		diagnostic.Column, diagnostic.EndColumn = origin.span(value)
+	}
+	return diagnostic
+}
+
+// span locates value in the origin line, falling back to the whole trimmed line.
+func (message *originMessage) span(value string) (int, int) {
+	text := message.Text
+	start := -1
+	if len(value) > 0 {
+		start = strings.Index(text, value)
+	}
+	end := start + len(value)
+	if start < 0 {
+		trimmed := strings.TrimSpace(text)
+		start = strings.Index(text, trimmed)
+		end = start + len(trimmed)
+	}
+	return utf8.RuneCountInString(text[:start]) + 1, utf8.RuneCountInString(text[:end]) + 1
+}
+
+func (diagnostics *Diagnostics) report(severity, code string, node *astNode, origin *originMessage, format string, args ...interface{}) {
+	diagnostic := newDiagnostic(severity, code, node, origin, fmt.Sprintf(format, args...))
+	*diagnostics = append(*diagnostics, diagnostic)
+	switch severity {
+	case DIAGNOSTIC_ERROR:
+		diagnosticLogger.Error(diagnostic.String())
+	case DIAGNOSTIC_WARNING:
+		diagnosticLogger.Warning(diagnostic.String())
+	default:
+		diagnosticLogger.Info(diagnostic.String())
+	}
+}
+
+func (diagnostics Diagnostics) HasError() bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == DIAGNOSTIC_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+func (diagnostic Diagnostic) String() string {
+	if len(diagnostic.File) == 0 {
+		return diagnostic.Message
+	}
+	return "[" + path.Base(diagnostic.File) + "] L" + strconv.Itoa(diagnostic.Line) + " " + diagnostic.Message
+}
@@ -0,0 +1,141 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CorpusDeck is one deck of a corpus, such as the decks two identifiers are compared on.
+type CorpusDeck struct {
+	Key  string
+	Deck ygopro_data.Deck
+}
+
+// DeckChange is a deck the two identifiers recognize differently, by name or by tags.
+type DeckChange struct {
+	Key     string
+	OldDeck string
+	OldTags []string
+	NewDeck string
+	NewTags []string
+}
+
+// DiffTransition counts the changed decks going from one name to another. Decks keeping their name but
+// not their tags are counted under a transition from the name to itself.
+type DiffTransition struct {
+	From  string
+	To    string
+	Count int
+	Keys  []string
+}
+
+type DiffReport struct {
+	Old         *Identifier
+	New         *Identifier
+	Total       int
+	Changes     []DeckChange
+	Transitions []DiffTransition
+	// Errors are the decks of the corpus which couldn't be read, by key.
+	Errors map[string]string
+}
+
+// DiffRequest asks to compare identifier Old with identifier New, or with Definition compiled as a preview of New
+// (of Old when New is left empty). Without decks, the test corpus of Old is used.
+type DiffRequest struct {
+	Old        string      `json:"-"`
+	New        string      `json:"new"`
+	Definition string      `json:"definition"`
+	Decks      []BatchDeck `json:"decks"`
+	Separate   bool        `json:"separate"`
+}
+
+// LoadCorpus reads a batch as a corpus. Decks which can't be read are returned apart.
+func LoadCorpus(decks []BatchDeck, separate bool) ([]CorpusDeck, map[string]string) {
+	corpus := make([]CorpusDeck, 0, len(decks))
+	errors := make(map[string]string)
+	for index := range decks {
+		deck, err := decks[index].Load()
+		if err != nil {
+			errors[decks[index].Key] = err.Error()
+			continue
+		}
+		corpus = append(corpus, CorpusDeck{decks[index].Key, PrepareDeck(deck, separate)})
+	}
+	return corpus, errors
+}
+
+// DeckTestCorpus takes the decks of a test corpus as a corpus, keyed by their path.
+func DeckTestCorpus(tests []DeckTest) []CorpusDeck {
+	corpus := make([]CorpusDeck, 0, len(tests))
+	for _, test := range tests {
+		corpus = append(corpus, CorpusDeck{test.Path, test.Deck})
+	}
+	return corpus
+}
+
+// DiffIdentifiers recognizes every deck of the corpus with both identifiers and reports the ones they disagree on.
+// Transitions come most frequent first.
+func DiffIdentifiers(before *Identifier, after *Identifier, corpus []CorpusDeck) *DiffReport {
+	report := &DiffReport{Old: before, New: after, Total: len(corpus), Changes: make([]DeckChange, 0), Transitions: make([]DiffTransition, 0), Errors: make(map[string]string)}
+	transitions := make(map[[2]string]int)
+	for _, item := range corpus {
+		oldDeck, oldTags := before.recognizeNames(item.Deck)
+		newDeck, newTags := after.recognizeNames(item.Deck)
+		sort.Strings(oldTags)
+		sort.Strings(newTags)
+		if oldDeck == newDeck && strings.Join(oldTags, "\n") == strings.Join(newTags, "\n") {
+			continue
+		}
+		report.Changes = append(report.Changes, DeckChange{item.Key, oldDeck, oldTags, newDeck, newTags})
+		key := [2]string{oldDeck, newDeck}
+		index, ok := transitions[key]
+		if !ok {
+			index = len(report.Transitions)
+			transitions[key] = index
+			report.Transitions = append(report.Transitions, DiffTransition{From: oldDeck, To: newDeck, Keys: make([]string, 0)})
+		}
+		report.Transitions[index].Count += 1
+		report.Transitions[index].Keys = append(report.Transitions[index].Keys, item.Key)
+	}
+	sort.SliceStable(report.Transitions, func(i, j int) bool {
+		return report.Transitions[i].Count > report.Transitions[j].Count
+	})
+	return report
+}
+
+// Diff runs the request on the current versions of the identifiers. ok is false when one of them doesn't exist.
+func (request *DiffRequest) Diff() (*DiffReport, Diagnostics, bool) {
+	oldWrapper, ok := GlobalIdentifierMap[request.Old]
+	if !ok {
+		return nil, nil, false
+	}
+	newName := request.New
+	if len(newName) == 0 {
+		newName = request.Old
+	}
+	newWrapper, ok := GlobalIdentifierMap[newName]
+	if !ok {
+		return nil, nil, false
+	}
+	before := oldWrapper.Current()
+	after := newWrapper.Current()
+	diagnostics := make(Diagnostics, 0)
+	if len(request.Definition) > 0 {
+		after = newWrapper.NewPreview(request.Definition, "preview")
+		diagnostics = append(diagnostics, after.Diagnostics...)
+	}
+	var corpus []CorpusDeck
+	errors := make(map[string]string)
+	if request.Decks != nil {
+		corpus, errors = LoadCorpus(request.Decks, request.Separate)
+	} else if _, err := os.Stat(oldWrapper.GetTestPath()); err == nil {
+		tests, testDiagnostics := LoadDeckTests([]string{oldWrapper.GetTestPath()})
+		corpus = DeckTestCorpus(tests)
+		diagnostics = append(diagnostics, testDiagnostics...)
+	}
+	report := DiffIdentifiers(before, after, corpus)
+	report.Errors = errors
+	return report, diagnostics, true
+}
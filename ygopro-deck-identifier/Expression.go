@@ -0,0 +1,193 @@
+package ygopro_deck_identifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expressions are evaluated in ten-thousandths, which keeps a percentage with two decimals of a count exact.
+const EXPRESSION_SCALE = 10000
+
+const EXPRESSION_COUNT = "count"
+const EXPRESSION_SIZE = "size"
+const EXPRESSION_NUMBER = "number"
+
+var expressionDecimal = `(\d+(?:\.\d{1,2})?)`
+var expressionCoefficientReg, _ = regexp.Compile(`^` + expressionDecimal + `\s*\*\s*(.+)$`)
+var expressionTrailingCoefficientReg, _ = regexp.Compile(`^(.+?)\s*\*\s*` + expressionDecimal + `$`)
+var expressionPercentReg, _ = regexp.Compile(`^` + expressionDecimal + `%\s*(main|side|ex|ori|all)?$`)
+var expressionNumberReg, _ = regexp.Compile(`^` + expressionDecimal + `$`)
+var expressionRangeReg, _ = regexp.Compile(`^(.+?)\s+(main|side|ex|ori|all)$`)
+
+// Expression is a sum of terms over a deck, such as "[影依] main + 2 * 影依融合 - 40% main".
+type Expression struct {
+	Text  string
+	Terms []ExpressionTerm
+}
+
+// ExpressionTerm is Factor times the count of the Ids in Range, the size of Range, or 1 for a number.
+// Factor is in EXPRESSION_SCALE and negative for subtracted terms.
+type ExpressionTerm struct {
+	Kind   string
+	Name   string
+	Ids    []int
+	Range  string
+	Factor int
+}
+
+// ParseExpression reads an expression, asking resolve for the cards an operand such as "[set]", "{filter}"
+// or a card name stands for.
+func ParseExpression(text string, resolve func(operand string) ([]int, error)) (Expression, error) {
+	expression := Expression{Text: strings.TrimSpace(text)}
+	sign := 1
+	for _, part := range splitExpression(expression.Text) {
+		if part == "+" || part == "-" {
+			if part == "-" {
+				sign = -1
+			}
+			continue
+		}
+		term, err := parseExpressionTerm(part, resolve)
+		if err != nil {
+			return expression, err
+		}
+		term.Factor *= sign
+		expression.Terms = append(expression.Terms, term)
+		sign = 1
+	}
+	if len(expression.Terms) == 0 {
+		return expression, errors.New("expression is empty")
+	}
+	return expression, nil
+}
+
+// splitExpression cuts the text at the "+" and "-" standing apart between spaces, so names like "E-HERO"
+// and the insides of sets and filters stay whole.
+func splitExpression(text string) []string {
+	parts := make([]string, 0)
+	depth := 0
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[', '{':
+			depth += 1
+		case ']', '}':
+			depth -= 1
+		case '+', '-':
+			if depth == 0 && (i == 0 || text[i-1] == ' ') && (i+1 == len(text) || text[i+1] == ' ') {
+				if part := strings.TrimSpace(text[start:i]); len(part) > 0 {
+					parts = append(parts, part)
+				}
+				parts = append(parts, text[i:i+1])
+				start = i + 1
+			}
+		}
+	}
+	if part := strings.TrimSpace(text[start:]); len(part) > 0 {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func parseExpressionTerm(text string, resolve func(operand string) ([]int, error)) (ExpressionTerm, error) {
+	term := ExpressionTerm{Name: text, Range: "all"}
+	if matches := expressionPercentReg.FindStringSubmatch(text); matches != nil {
+		term.Kind = EXPRESSION_SIZE
+		term.Factor = parseHundredths(matches[1])
+		if len(matches[2]) > 0 {
+			term.Range = matches[2]
+		}
+		return term, nil
+	}
+	if expressionNumberReg.MatchString(text) {
+		term.Kind = EXPRESSION_NUMBER
+		term.Range = ""
+		term.Factor = parseHundredths(text) * 100
+		return term, nil
+	}
+	coefficient := 100
+	if matches := expressionCoefficientReg.FindStringSubmatch(text); matches != nil {
+		coefficient, text = parseHundredths(matches[1]), matches[2]
+	} else if matches := expressionTrailingCoefficientReg.FindStringSubmatch(text); matches != nil {
+		text, coefficient = matches[1], parseHundredths(matches[2])
+	}
+	term.Factor = coefficient * 100
+	if strings.HasSuffix(text, "%") || expressionPercentReg.MatchString(text) {
+		return term, fmt.Errorf("percentage %v can't take a coefficient", text)
+	}
+	switch text {
+	case "main", "side", "ex", "ori", "all":
+		term.Kind = EXPRESSION_SIZE
+		term.Range = text
+		return term, nil
+	}
+	if matches := expressionRangeReg.FindStringSubmatch(text); matches != nil {
+		text, term.Range = matches[1], matches[2]
+	}
+	ids, err := resolve(text)
+	if err != nil {
+		return term, err
+	}
+	term.Kind = EXPRESSION_COUNT
+	term.Ids = ids
+	return term, nil
+}
+
+func parseHundredths(text string) int {
+	value, _ := strconv.ParseFloat(text, 64)
+	return int(value*100 + 0.5)
+}
+
+// Evaluate the expression on the deck, in EXPRESSION_SCALE.
+func (expression Expression) Evaluate(deck *ygopro_data.Deck) int {
+	value := 0
+	for _, term := range expression.Terms {
+		value += term.Factor * term.value(deck)
+	}
+	return value
+}
+
+func (term ExpressionTerm) value(deck *ygopro_data.Deck) int {
+	target := GetDeckTargetClassifiedRange(deck, term.Range)
+	count := 0
+	switch term.Kind {
+	case EXPRESSION_NUMBER:
+		return 1
+	case EXPRESSION_SIZE:
+		for _, value := range *target {
+			count += value
+		}
+	case EXPRESSION_COUNT:
+		for _, id := range term.Ids {
+			count += (*target)[id]
+		}
+	}
+	return count
+}
+
+// splitComparison cuts "left >= right" at its comparison, skipping the ones inside card filters.
+func splitComparison(text string) (string, string, string, bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '[', '{':
+			depth += 1
+		case ']', '}':
+			depth -= 1
+		case '>', '<', '=':
+			if depth > 0 {
+				continue
+			}
+			operator := text[i : i+1]
+			if i+1 < len(text) && text[i+1] == '=' {
+				operator = text[i : i+2]
+			}
+			return strings.TrimSpace(text[:i]), operator, strings.TrimSpace(text[i+len(operator):]), true
+		}
+	}
+	return "", "", "", false
+}
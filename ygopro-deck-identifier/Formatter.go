@@ -0,0 +1,327 @@
+package ygopro_deck_identifier
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Formatter re-emits a compiled definition tree in the canonical spelling of the DSL:
+// explicit line types, two spaces per level, "==" for equality, no "all" range and
+// priorities folded into the classification line. Comments follow the node they were written above.
+type Formatter struct {
+	buffer bytes.Buffer
+}
+
+// FormatDefinition returns the canonical text of a definition. Definitions with lines the compiler
+// can't parse are returned untouched together with the errors, since formatting them would drop those lines.
+func FormatDefinition(content string, filename string) (string, Diagnostics) {
+	compiler := new(Compiler)
+	compiler.CompileNamedString(content, filename)
+	errors := make(Diagnostics, 0)
+	for _, diagnostic := range compiler.Diagnostics {
+		if diagnostic.Severity == DIAGNOSTIC_ERROR {
+			errors = append(errors, diagnostic)
+		}
+	}
+	if len(errors) > 0 {
+		return content, errors
+	}
+	formatter := new(Formatter)
+	formatter.formatRoot(compiler.Root)
+	return formatter.buffer.String(), nil
+}
+
+// FormatFile formats a definition file, returning the canonical text and whether it differs from the file.
+func FormatFile(filename string) (string, bool, Diagnostics) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "file-unreadable", nil, newOriginMessage(0, "", filename), "Failed to read file: "+err.Error())}
+	}
+	formatted, diagnostics := FormatDefinition(string(content), filename)
+	return formatted, formatted != string(content), diagnostics
+}
+
+// CheckFormat lists the definition files of the identifier which are not canonically formatted.
+func (identifier *IdentifierWrapper) CheckFormat() ([]string, Diagnostics) {
+	files := make([]string, 0)
+	diagnostics := make(Diagnostics, 0)
+	for _, name := range identifier.GetFileList() {
+		_, changed, fileDiagnostics := FormatFile(filepath.Join(identifier.GetPath(), name))
+		diagnostics = append(diagnostics, fileDiagnostics...)
+		if changed || len(fileDiagnostics) > 0 {
+			files = append(files, name)
+		}
+	}
+	return files, diagnostics
+}
+
+// FormatPaths formats every .deckdef file under the given paths. With write it rewrites the files,
+// it always returns the files which were not formatted.
+func FormatPaths(paths []string, write bool) ([]string, Diagnostics) {
+	files := make([]string, 0)
+	diagnostics := make(Diagnostics, 0)
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || (path != root && !strings.HasSuffix(path, ".deckdef")) {
+				return nil
+			}
+			formatted, changed, fileDiagnostics := FormatFile(path)
+			diagnostics = append(diagnostics, fileDiagnostics...)
+			if len(fileDiagnostics) > 0 {
+				files = append(files, path)
+			} else if changed {
+				files = append(files, path)
+				if write {
+					if err := ioutil.WriteFile(path, []byte(formatted), info.Mode()); err != nil {
+						diagnostics = append(diagnostics, newDiagnostic(DIAGNOSTIC_ERROR, "file-unwritable", nil, newOriginMessage(0, "", path), "Failed to write file: "+err.Error()))
+					}
+				}
+			}
+			return nil
+		})
+	}
+	return files, diagnostics
+}
+
+func (formatter *Formatter) formatRoot(root *astNode) {
+	written := 0
+	for _, node := range root.Children {
+		// Nodes expanded from a template are written as the use line which made them.
+		if node.Origin != nil && node.Origin.ExpandedFrom != nil {
+			continue
+		}
+		if written > 0 {
+			formatter.buffer.WriteString("\n")
+		}
+		formatter.formatNode(node, 0)
+		written += 1
+	}
+	if len(root.Comments) > 0 && len(root.Children) > 0 {
+		formatter.buffer.WriteString("\n")
+	}
+	for _, comment := range root.Comments {
+		formatter.writeLine(0, comment, "")
+	}
+}
+
+func (formatter *Formatter) formatNode(node *astNode, depth int) {
+	for _, comment := range node.Comments {
+		formatter.writeLine(depth, comment, "")
+	}
+	children := make([]*astNode, 0)
+	var text string
+	switch node.Type {
+	case "deck", "tag", "check tag", "force tag", "refuse tag":
+		priority := ""
+		for _, child := range node.Children {
+			if child.Type == "priority" {
+				priority = child.Value
+			} else {
+				children = append(children, child)
+			}
+		}
+		text = classificationKeyword(node.Type) + ": " + escapeDefinitionText(node.Value)
+		if isDigits(priority) {
+			if strings.TrimLeft(priority, "0") != "" {
+				text += " [" + priority + "]"
+			}
+		} else {
+			children = append([]*astNode{newAstNode("priority", priority)}, children...)
+		}
+	case "restrain":
+		text, children = formatter.formatRestrain(node)
+	case "template":
+		formatter.writeLine(depth, "template: "+node.Value, node.Comment)
+		// The body is only text until used, it is kept as written.
+		for _, line := range node.Children {
+			formatter.buffer.WriteString(strings.Repeat(tabSpaceString, depth+1) + line.Value + "\n")
+		}
+		return
+	case "set card":
+		text = "set card: " + escapeDefinitionText(node.Value)
+		if isBareSetMember(node.Value) {
+			text = escapeDefinitionText(node.Value)
+		}
+		children = node.Children
+	case "set code":
+		text = "setcode: " + escapeDefinitionText(node.Value)
+		children = node.Children
+	case "inner set":
+		text = "inner set: " + escapeDefinitionText(node.Value)
+		if strings.HasPrefix(node.Value, "[") && strings.HasSuffix(node.Value, "]") && isBareSetMember(strings.Trim(node.Value, "[]")) {
+			text = escapeDefinitionText(node.Value)
+		}
+		children = node.Children
+	default:
+		text = node.Type + ": " + escapeDefinitionText(node.Value)
+		children = node.Children
+	}
+	formatter.writeLine(depth, text, node.Comment)
+	for _, child := range children {
+		formatter.formatNode(child, depth+1)
+	}
+}
+
+// formatRestrain returns the line of a restrain node and the children which still need their own lines.
+// Restrains written as one "!" expression stay one expression.
+func (formatter *Formatter) formatRestrain(node *astNode) (string, []*astNode) {
+	inline, rest := splitInlineChildren(node)
+	switch {
+	case node.Value == "compare":
+		return formatCompare(node), nil
+	case isLeafRestrain(node):
+		target, restrainRange, condition := leafRestrainParts(node)
+		return node.Value + ": " + strings.Join(strings.Fields(target+" "+restrainRange+" "+condition), " "), rest
+	case len(inline) > 0:
+		return COMPILER_RESTRAIN_IDENTIFIER + " " + formatRestrainExpression(node, ""), rest
+	case node.Value == "and" || node.Value == "or":
+		return node.Value + ":", node.Children
+	default:
+		return "restrains: " + formatCondition(node.Value), node.Children
+	}
+}
+
+// formatRestrainExpression renders a restrain tree parsed from a "!" line back into one expression,
+// parenthesizing whatever the parser would otherwise group differently.
+func formatRestrainExpression(node *astNode, parent string) string {
+	if isLeafRestrain(node) {
+		target, restrainRange, condition := leafRestrainParts(node)
+		if node.Value == "set" {
+			target = "[" + target + "]"
+		}
+		return strings.Join(strings.Fields(target+" "+restrainRange+" "+condition), " ")
+	}
+	inline, _ := splitInlineChildren(node)
+	parts := make([]string, 0)
+	for _, child := range inline {
+		parts = append(parts, formatRestrainExpression(child, node.Value))
+	}
+	var text string
+	switch node.Value {
+	case "not":
+		text = "not " + strings.Join(parts, " ")
+	case "and", "or":
+		text = strings.Join(parts, " "+node.Value+" ")
+	default:
+		text = strings.Join(parts, " ")
+	}
+	if parent == "not" || parent == node.Value || (parent == "and" && node.Value == "or") {
+		text = "(" + text + ")"
+	}
+	return text
+}
+
+// splitInlineChildren separates the children parsed from the node's own line from those written below it.
+func splitInlineChildren(node *astNode) ([]*astNode, []*astNode) {
+	inline := make([]*astNode, 0)
+	rest := make([]*astNode, 0)
+	for _, child := range node.Children {
+		if child.Type == "restrain" && child.Origin == node.Origin {
+			inline = append(inline, child)
+		} else if !isLeafRestrain(node) || child.Type == "restrain" {
+			rest = append(rest, child)
+		}
+	}
+	return inline, rest
+}
+
+func formatCompare(node *astNode) string {
+	parts := make([]string, 0)
+	for _, child := range node.Children {
+		switch child.Type {
+		case "left", "right":
+			parts = append(parts, strings.Join(strings.Fields(escapeDefinitionText(child.Value)), " "))
+		case "condition":
+			parts = append(parts, child.Value)
+		}
+	}
+	return "compare: " + strings.Join(parts, " ")
+}
+
+func isLeafRestrain(node *astNode) bool {
+	return node.Value == "card" || node.Value == "set" || node.Value == "property" || node.Value == "size" || node.Value == "distinct"
+}
+
+func leafRestrainParts(node *astNode) (string, string, string) {
+	target, restrainRange, condition := "", "", ""
+	for _, child := range node.Children {
+		switch child.Type {
+		case "target":
+			target = escapeDefinitionText(child.Value)
+			if node.Value == "property" {
+				target = "{" + strings.Join(strings.Fields(target), " ") + "}"
+			}
+		case "range":
+			if child.Value != "all" {
+				restrainRange = child.Value
+			}
+		case "condition":
+			condition = formatCondition(child.Value)
+		}
+	}
+	return target, restrainRange, condition
+}
+
+func formatCondition(value string) string {
+	matches := conditionStringReg.FindStringSubmatch(value)
+	if matches == nil {
+		return strings.TrimSpace(value)
+	}
+	operator := matches[1]
+	if operator == "=" {
+		operator = "=="
+	}
+	return strings.TrimSpace(operator + " " + matches[3])
+}
+
+func classificationKeyword(class string) string {
+	switch class {
+	case "force tag":
+		return "force"
+	case "refuse tag":
+		return "refuse"
+	case "check tag":
+		return "tag"
+	}
+	return class
+}
+
+// isBareSetMember tells whether a set member line can go without its line type and still be read the same.
+func isBareSetMember(value string) bool {
+	return len(value) > 0 &&
+		!strings.ContainsAny(value, COMPILER_TYPE_SPLIT_CHARACTER+"()[]{}") &&
+		!strings.HasPrefix(value, COMPILER_RESTRAIN_IDENTIFIER) &&
+		!restrainReg.MatchString(value)
+}
+
+func escapeDefinitionText(value string) string {
+	return strings.Replace(value, COMPILER_COMMENT_CHARACTER, "\\"+COMPILER_COMMENT_CHARACTER, -1)
+}
+
+func isDigits(value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for _, character := range value {
+		if character < '0' || character > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (formatter *Formatter) writeLine(depth int, text string, comment string) {
+	text = strings.TrimSpace(text)
+	formatter.buffer.WriteString(strings.Repeat(tabSpaceString, depth))
+	formatter.buffer.WriteString(text)
+	if len(comment) > 0 {
+		if len(text) > 0 {
+			formatter.buffer.WriteString(" ")
+		}
+		formatter.buffer.WriteString(comment)
+	}
+	formatter.buffer.WriteString("\n")
+}
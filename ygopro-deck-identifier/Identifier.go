@@ -17,7 +17,19 @@ type Identifier struct {
 	GlobalTags []Tag
 	CustomSets []ygopro_data.Set
 
+	// Diagnostics collects every problem found by the compiler and the prepare pipeline.
+	Diagnostics Diagnostics
+
+	// Generation counts the reloads of the owning wrapper, it is 0 before the first one.
+	Generation uint64
+
+	// files are the definition files registered so far, by absolute path.
+	files map[string]bool
+	// templates are the templates each registered file defines or imports, by absolute path.
+	templates map[string]map[string]*astNode
+
 	prototype          *astIdentifier
+	plan               *EvaluationPlan
 	BindingEnvironment *ygopro_data.Environment
 	SetNameHash        map[string]ygopro_data.Set
 }
@@ -32,7 +44,7 @@ func NewIdentifier(name string) *Identifier {
 
 func (identifier *Identifier) RegisterFolder(dirName string) {
 	filepath.Walk(dirName, func(path string, info os.FileInfo, err error) error {
-		if strings.HasSuffix(path, ".deckdef") {
+		if strings.HasSuffix(path, DECKDEF_EXTENSION) {
 			identifier.RegisterDSLFile(path)
 		}
 		return nil
@@ -40,19 +52,22 @@ func (identifier *Identifier) RegisterFolder(dirName string) {
 }
 
 func (identifier *Identifier) RegisterDSLFile(filename string) {
-	compiler := new(Compiler)
-	compiler.CompileFile(filename)
-	identifier.prototype.registerNode(compiler.Root)
+	identifier.registerFile(filename, nil, nil)
 }
 
+// RegisterDSL registers a definition not read from a file, its imports are only looked up in the library.
 func (identifier *Identifier) RegisterDSL(string string) {
 	compiler := new(Compiler)
 	compiler.CompileString(string)
+	compiler.ExpandImportedTemplates(identifier.registerImports(compiler.Root, "", nil))
+	identifier.Diagnostics = append(identifier.Diagnostics, compiler.Diagnostics...)
 	identifier.prototype.registerNode(compiler.Root)
 }
 
 func (identifier *Identifier) Ready(backup *Identifier) {
 	identifier.prototype.prepare(identifier, backup)
+	identifier.Diagnostics = append(identifier.Diagnostics, identifier.prototype.diagnostics...)
+	identifier.plan = newEvaluationPlan(identifier)
 	Logger.Noticef("Identifier %v is Ready, %d Decks, %d Tags (%d is Global), %d Custom Sets loaded.", identifier.Name, len(identifier.Decks), len(identifier.Tags), len(identifier.GlobalTags), len(identifier.CustomSets))
 }
 
@@ -61,13 +76,28 @@ func (identifier *Identifier) clear() {
 	identifier.Tags = identifier.Tags[:0]
 	identifier.GlobalTags = identifier.GlobalTags[:0]
 	identifier.CustomSets = identifier.CustomSets[:0]
+	identifier.Diagnostics = nil
 	identifier.prototype.clear()
 	identifier.SetNameHash = make(map[string]ygopro_data.Set)
+	identifier.files = make(map[string]bool)
+	identifier.templates = make(map[string]map[string]*astNode)
+	identifier.plan = nil
 }
 
 func (identifier *Identifier) Recognize(deck ygopro_data.Deck) *Result {
-	result := identifier.recognizeDeck(deck)
-	tags := identifier.recognizeTags(deck)
+	if identifier.plan == nil {
+		return identifier.RecognizeLinearly(deck)
+	}
+	return identifier.combine(identifier.plan.recognize(deck))
+}
+
+// RecognizeLinearly evaluates every deck and global tag in order without the evaluation plan.
+// The plan must always agree with it.
+func (identifier *Identifier) RecognizeLinearly(deck ygopro_data.Deck) *Result {
+	return identifier.combine(identifier.recognizeDeck(deck), identifier.recognizeTags(deck))
+}
+
+func (identifier *Identifier) combine(result *Result, tags []Tag) *Result {
 	if result == nil {
 		return identifier.polymerize(tags)
 	}
@@ -138,10 +168,19 @@ func (identifier *Identifier) generateSetHash() {
 	}
 }
 
+// lookupNamedSet only reads the sets the identifier already knows. Published identifiers are read by requests at
+// the same time, so other identifiers, such as previews backed by one, must look sets up this way.
+func (identifier *Identifier) lookupNamedSet(name string) (ygopro_data.Set, bool) {
+	set, ok := identifier.SetNameHash[name]
+	return set, ok
+}
+
+// searchNamedSet also searches the environment for cards carrying the name, and keeps the result as a custom set
+// of this identifier. Only the identifier being prepared may be searched.
 func (identifier *Identifier) searchNamedSet(name string) (ygopro_data.Set, bool) {
 	if len(name) == 0 {
 		Logger.Warning("Try to search card set named EMPTY.")
-	} else if set, ok := identifier.SetNameHash[name]; ok {
+	} else if set, ok := identifier.lookupNamedSet(name); ok {
 		return set, true
 	} else if set := identifier.BindingEnvironment.GetAllNamedCard(name); len(set.Ids) > 0 {
 		Logger.Infof("Created searched Set named %v under environment %v with %d cards.", name, identifier.BindingEnvironment.Locale, len(set.Ids))
@@ -1,19 +1,22 @@
 package ygopro_deck_identifier
 
 import (
-	"bytes"
 	"github.com/iamipanda/ygopro-data"
-	"github.com/op/go-logging"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// IdentifierWrapper keeps the published version of an identifier. A reload compiles a whole new
+// Identifier off to the side and swaps it in, so recognition always runs on one complete version.
 type IdentifierWrapper struct {
-	Identifier
+	Name      string
+	snapshot  atomic.Value
 	resetLock chan int
 }
 
@@ -25,14 +28,27 @@ func GetWrappedIdentifier(name string) *IdentifierWrapper {
 	} else {
 		identifier := new(IdentifierWrapper)
 		identifier.Name = name
-		identifier.prototype = new(astIdentifier)
-		identifier.BindingEnvironment = ygopro_data.GetEnvironment("zh-CN")
 		identifier.resetLock = make(chan int, 1)
+		empty := NewIdentifier(name)
+		empty.clear()
+		identifier.snapshot.Store(empty)
 		GlobalIdentifierMap[name] = identifier
 		return identifier
 	}
 }
 
+// Current returns the identifier published by the last reload. A request should take it once
+// and keep using it, so a reload in between can't mix two versions of the definitions.
+func (identifier *IdentifierWrapper) Current() *Identifier {
+	return identifier.snapshot.Load().(*Identifier)
+}
+
+// publish swaps in a prepared identifier as the next generation. The caller must hold the reset lock.
+func (identifier *IdentifierWrapper) publish(next *Identifier) {
+	next.Generation = identifier.Current().Generation + 1
+	identifier.snapshot.Store(next)
+}
+
 func RegisterIdentifiersAccordingToConfig() {
 	for _, name := range Config.IdentifierNames {
 		identifier := GetWrappedIdentifier(name)
@@ -40,17 +56,29 @@ func RegisterIdentifiersAccordingToConfig() {
 	}
 }
 
+// RecognizeAsJson answers a recognition of the API, counted in the metrics.
 func (identifier *Identifier) RecognizeAsJson(deck ygopro_data.Deck) (json map[string]interface{}) {
+	json = identifier.recognitionJson(deck)
+	tags, _ := json["tag"].([]string)
+	Metrics.recordRecognition(identifier.Name, json["deck"].(string), tags)
+	return json
+}
+
+func (identifier *Identifier) recognitionJson(deck ygopro_data.Deck) (json map[string]interface{}) {
 	result := identifier.Recognize(deck)
 	if result != nil {
 		result.processAffixAndGetName(true)
 	}
-	return result.ToJson()
+	json = result.ToJson()
+	json["generation"] = identifier.Generation
+	return json
 }
 
 func (identifier *Identifier) VerboseRecognizeAsJson(deck ygopro_data.Deck) (json map[string]interface{}) {
 	result := identifier.verboseRecognize(deck)
-	return result.ToJson()
+	json = result.ToJson()
+	json["generation"] = identifier.Generation
+	return json
 }
 
 func (identifier *IdentifierWrapper) GetPath() string {
@@ -105,43 +133,41 @@ func (identifier *IdentifierWrapper) GetFile(filename string) (string, bool) {
 	}
 }
 
-var ReloadReport bytes.Buffer
-
-func (identifier *IdentifierWrapper) Reload() (bool, string) {
+func (identifier *IdentifierWrapper) Reload() (bool, Diagnostics) {
 	// lock
 	identifier.resetLock <- 1
+	defer func() { <-identifier.resetLock }()
 
-	// Logger hook
-	ReloadReport.Reset()
-	backend := logging.AddModuleLevel(logging.NewLogBackend(&ReloadReport, "", 0))
-	backend.SetLevel(logging.NOTICE, "")
-	logging.SetBackend(NormalLoggingBackend, backend)
+	return identifier.reload()
+}
 
+// reload publishes the definitions on disk. The caller must hold the reset lock.
+func (identifier *IdentifierWrapper) reload() (bool, Diagnostics) {
+	start := time.Now()
 	if !identifier.CheckPathExist() {
-		return false, ""
+		diagnostics := Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "path-missing", nil, nil, "Identifier path "+identifier.GetPath()+" doesn't exist.")}
+		Metrics.recordReload(identifier.Current(), false, diagnostics, time.Since(start))
+		return false, diagnostics
 	}
-	identifier.clear()
-	identifier.RegisterFolder(identifier.GetPath())
-	identifier.Ready(nil)
-
-	// FIXME: Make it graceful.
-	logging.SetBackend(NormalLoggingBackend)
-
-	<-identifier.resetLock
-	return true, ReloadReport.String()
+	next := NewIdentifier(identifier.Name)
+	next.clear()
+	next.RegisterFolder(identifier.GetPath())
+	next.Ready(nil)
+	identifier.publish(next)
+	Metrics.recordReload(next, true, next.Diagnostics, time.Since(start))
+	return true, next.Diagnostics
 }
 
-func ReloadAllIdentifier() (bool, string) {
+func ReloadAllIdentifier() (bool, map[string]Diagnostics) {
 	// Due the reload() in identifier is locked, this function needed another lock.
 	ok := true
-	var log bytes.Buffer
-	for _, identifier := range GlobalIdentifierMap {
-		identifierOk, identifierLog := identifier.Reload()
+	reports := make(map[string]Diagnostics)
+	for name, identifier := range GlobalIdentifierMap {
+		identifierOk, diagnostics := identifier.Reload()
 		ok = ok && identifierOk
-		log.WriteString(identifierLog)
-		log.WriteByte('\n')
+		reports[name] = diagnostics
 	}
-	return ok, log.String()
+	return ok, reports
 }
 
 func (identifier *IdentifierWrapper) SetFile(filename, content string) (string, bool) {
@@ -193,47 +219,50 @@ func (identifier *IdentifierWrapper) Push(message string) (string, bool) {
 }
 
 func (identifier *IdentifierWrapper) GetRuntimeList() (list map[string]interface{}) {
+	current := identifier.Current()
 	list = make(map[string]interface{})
 	deckNames := make([]string, 0)
 	tagNames := make([]string, 0)
 	setNames := make([]string, 0)
-	for _, deck := range identifier.Decks {
+	for _, deck := range current.Decks {
 		deckNames = append(deckNames, deck.Name)
 	}
-	for _, tag := range identifier.Tags {
+	for _, tag := range current.Tags {
 		tagNames = append(tagNames, tag.Name)
 	}
-	for _, set := range identifier.CustomSets {
+	for _, set := range current.CustomSets {
 		setNames = append(setNames, set.Name)
 	}
 	list["decks"] = deckNames
 	list["tags"] = tagNames
 	list["sets"] = setNames
+	list["generation"] = current.Generation
 	return list
 }
 
 func (identifier *IdentifierWrapper) GetRuntimeStructure(class, name string) (map[string]interface{}, bool) {
+	current := identifier.Current()
 	class = strings.ToLower(class)
 	switch class {
 	case "deck":
-		for _, deck := range identifier.Decks {
+		for _, deck := range current.Decks {
 			if deck.Name == name {
 				return deck.ToJson(), true
 			}
 		}
 	case "tag":
-		for _, tag := range identifier.Tags {
+		for _, tag := range current.Tags {
 			if tag.Name == name {
 				return tag.ToJson(), true
 			}
 		}
 	case "set":
-		for _, set := range identifier.CustomSets {
+		for _, set := range current.CustomSets {
 			if set.Name == name {
 				return SetToJson(set), true
 			}
 		}
-		for _, set := range identifier.BindingEnvironment.Sets {
+		for _, set := range current.BindingEnvironment.Sets {
 			if set.Name == name {
 				return SetToJson(set), true
 			}
@@ -243,20 +272,20 @@ func (identifier *IdentifierWrapper) GetRuntimeStructure(class, name string) (ma
 	return make(map[string]interface{}), false
 }
 
-func (identifier *IdentifierWrapper) GetCompilePreview(content string, newName string) (*IdentifierWrapper, string) {
-	// Logger hook
-	ReloadReport.Reset()
-	backend := logging.AddModuleLevel(logging.NewLogBackend(&ReloadReport, "", 0))
-	backend.SetLevel(logging.NOTICE, "")
-	logging.SetBackend(NormalLoggingBackend, backend)
-
+func (identifier *IdentifierWrapper) GetCompilePreview(content string, newName string) (*IdentifierWrapper, Diagnostics) {
 	target := GetWrappedIdentifier(newName)
-	target.clear()
-	target.RegisterDSL(content)
-	// Stupid golang
-	//mirror := Identifier{identifier.Name, identifier.Decks, identifier.Tags, identifier.GlobalTags, identifier.CustomSets, identifier.prototype, identifier.BindingEnvironment, identifier.SetNameHash}
-	target.Ready(&identifier.Identifier)
+	target.resetLock <- 1
+	defer func() { <-target.resetLock }()
+	preview := identifier.NewPreview(content, newName)
+	target.publish(preview)
+	return target, preview.Diagnostics
+}
 
-	logging.SetBackend(NormalLoggingBackend)
-	return target, ReloadReport.String()
+// NewPreview compiles content into an identifier of its own, backed by the current version, without publishing it anywhere.
+func (identifier *IdentifierWrapper) NewPreview(content string, newName string) *Identifier {
+	preview := NewIdentifier(newName)
+	preview.clear()
+	preview.RegisterDSL(content)
+	preview.Ready(identifier.Current())
+	return preview
 }
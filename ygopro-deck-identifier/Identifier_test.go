@@ -0,0 +1,57 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"strconv"
+	"testing"
+)
+
+// testCards stand in for the card database, the definitions of the tests name them. The environment also gets
+// testFillers cards no definition names, so random decks don't hold only wanted cards.
+var testCards = []ygopro_data.Card{
+	{Id: 1001, Name: "影依猎鹰", Setcode: 0x9d},
+	{Id: 1002, Name: "影依·巨人", Setcode: 0x9d},
+	{Id: 1003, Name: "影依·米德拉什", Setcode: 0x9d},
+	{Id: 1004, Name: "影依融合", Setcode: 0x9d},
+	{Id: 2001, Name: "机械士兵"},
+	{Id: 2002, Name: "机械战车"},
+	{Id: 2003, Name: "强欲之壶"},
+	{Id: 2004, Name: "神之宣告"},
+	{Id: 3001, Name: "XYZ大炮"},
+}
+
+const testFillers = 200
+
+// newTestIdentifier readies the definition against the test cards, the card database isn't needed.
+func newTestIdentifier(t testing.TB, definition string) *Identifier {
+	environment := &ygopro_data.Environment{Locale: "zh-CN", Cards: make(map[int]ygopro_data.Card)}
+	for _, card := range testCards {
+		card.Locale = environment.Locale
+		environment.Cards[card.Id] = card
+	}
+	for id := 9001; id <= 9000+testFillers; id++ {
+		environment.Cards[id] = ygopro_data.Card{Id: id, Name: "通常怪兽" + strconv.Itoa(id), Locale: environment.Locale}
+	}
+	ygopro_data.Environments[environment.Locale] = environment
+	identifier := NewIdentifier("test")
+	identifier.clear()
+	identifier.RegisterDSL(definition)
+	identifier.Ready(nil)
+	for _, diagnostic := range identifier.Diagnostics {
+		if diagnostic.Severity == DIAGNOSTIC_ERROR {
+			t.Fatalf("definition doesn't compile: %v", diagnostic.Message)
+		}
+	}
+	return identifier
+}
+
+// testDeck prepares a deck of the main cards, each id as many times as given.
+func testDeck(main map[int]int) ygopro_data.Deck {
+	deck := ygopro_data.Deck{}
+	for id, count := range main {
+		for i := 0; i < count; i++ {
+			deck.Main = append(deck.Main, id)
+		}
+	}
+	return PrepareDeck(deck, false)
+}
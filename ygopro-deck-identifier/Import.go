@@ -0,0 +1,139 @@
+package ygopro_deck_identifier
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const DECKDEF_EXTENSION = ".deckdef"
+
+// libraryPath is where imports not found next to the importing file are looked up.
+// Without a LibraryPath configured, the identifiers' own directories serve as the library.
+func libraryPath() string {
+	if len(Config.LibraryPath) > 0 {
+		return Config.LibraryPath
+	}
+	return Config.DeckDefPath
+}
+
+// registerFile compiles a definition file and whatever it imports. A file registered before is skipped,
+// so a library imported from several files, or also reached by RegisterFolder, only counts once.
+func (identifier *Identifier) registerFile(filename string, importer *originMessage, importing []string) {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		absolute = filename
+	}
+	if identifier.files == nil {
+		identifier.files = make(map[string]bool)
+	}
+	if identifier.files[absolute] {
+		return
+	}
+	identifier.files[absolute] = true
+	compiler := new(Compiler)
+	compiler.CompileFile(filename)
+	if importer != nil {
+		compiler.Root.setImporter(importer)
+	}
+	compiler.ExpandImportedTemplates(identifier.registerImports(compiler.Root, filepath.Dir(filename), append(importing, absolute)))
+	identifier.Diagnostics = append(identifier.Diagnostics, compiler.Diagnostics...)
+	if identifier.templates == nil {
+		identifier.templates = make(map[string]map[string]*astNode)
+	}
+	identifier.templates[absolute] = compiler.VisibleTemplates()
+	identifier.prototype.registerNode(compiler.Root)
+}
+
+// registerImports follows the import lines of a compiled file and returns the templates they make visible.
+// Directory is where relative imports start from, empty for definitions not read from a file; importing is the
+// chain of files leading here, to catch cycles.
+func (identifier *Identifier) registerImports(root *astNode, directory string, importing []string) map[string]*astNode {
+	templates := make(map[string]*astNode)
+	imported := func(path string) {
+		if absolute, err := filepath.Abs(path); err == nil {
+			for name, template := range identifier.templates[absolute] {
+				templates[name] = template
+			}
+		}
+	}
+	for _, node := range root.Children {
+		if node.Type != "import" {
+			continue
+		}
+		target, ok := resolveImport(node.Value, directory)
+		if !ok {
+			identifier.Diagnostics.report(DIAGNOSTIC_ERROR, "import-missing", node, nil, "Can't find imported file or directory %v", node.Value)
+			continue
+		}
+		absolute, err := filepath.Abs(target)
+		if err != nil {
+			absolute = target
+		}
+		if cycle := importCycle(absolute, importing); len(cycle) > 0 {
+			identifier.Diagnostics.report(DIAGNOSTIC_ERROR, "import-cycle", node, nil, "Import cycle: %v", strings.Join(cycle, " -> "))
+			continue
+		}
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && strings.HasSuffix(path, DECKDEF_EXTENSION) {
+					if absolute, err := filepath.Abs(path); err == nil && len(importCycle(absolute, importing)) == 0 {
+						identifier.registerFile(path, node.Origin, importing)
+						imported(path)
+					}
+				}
+				return nil
+			})
+		} else {
+			identifier.registerFile(target, node.Origin, importing)
+			imported(target)
+		}
+	}
+	return templates
+}
+
+// resolveImport finds the file or directory an import names, trying the extension left out.
+func resolveImport(name string, directory string) (string, bool) {
+	candidates := make([]string, 0)
+	if filepath.IsAbs(name) {
+		candidates = append(candidates, name)
+	} else {
+		if len(directory) > 0 {
+			candidates = append(candidates, filepath.Join(directory, name))
+		}
+		candidates = append(candidates, filepath.Join(libraryPath(), name))
+	}
+	for _, candidate := range candidates {
+		for _, path := range []string{candidate, candidate + DECKDEF_EXTENSION} {
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// importCycle returns the chain of files from the one target is imported by again, or nil without a cycle.
+// Paths are absolute; a directory import closes a cycle when one of the importing files lies inside it.
+func importCycle(target string, importing []string) []string {
+	for index, file := range importing {
+		if file == target || strings.HasPrefix(file, target+string(filepath.Separator)) {
+			cycle := make([]string, 0)
+			for _, file := range importing[index:] {
+				cycle = append(cycle, filepath.Base(file))
+			}
+			return append(cycle, filepath.Base(target))
+		}
+	}
+	return nil
+}
+
+// setImporter marks the nodes of an imported file with the import line that brought them in.
+func (node *astNode) setImporter(importer *originMessage) {
+	if node.Origin != nil && node.Origin.Importer == nil {
+		node.Origin.Importer = importer
+	}
+	for _, child := range node.Children {
+		child.setImporter(importer)
+	}
+}
@@ -2,6 +2,8 @@ package ygopro_deck_identifier
 
 import (
 	"github.com/iamipanda/ygopro-data"
+	"strings"
+	"time"
 )
 
 func (deckType *Deck) ToJson() (json map[string]interface{}) {
@@ -74,6 +76,56 @@ func (restrain SetRestrain) ToJson() (json map[string]interface{}) {
 	return json
 }
 
+func (restrain SizeRestrain) ToJson() (json map[string]interface{}) {
+	json = make(map[string]interface{})
+	json["type"] = restrain.Type()
+	json["range"] = restrain.Range
+	json["condition"] = restrain.Condition.ToJson()
+	return json
+}
+
+func (restrain CompareRestrain) ToJson() (json map[string]interface{}) {
+	json = make(map[string]interface{})
+	json["type"] = restrain.Type()
+	json["left"] = restrain.Left.ToJson()
+	json["right"] = restrain.Right.ToJson()
+	json["operator"] = restrain.Condition.operator
+	return json
+}
+
+// Expression#ToJson gives factors as plain numbers, the scale is only kept inside.
+func (expression Expression) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	terms := make([]interface{}, 0)
+	for _, term := range expression.Terms {
+		terms = append(terms, map[string]interface{}{
+			"kind":   term.Kind,
+			"name":   term.Name,
+			"range":  term.Range,
+			"cards":  len(term.Ids),
+			"factor": float64(term.Factor) / EXPRESSION_SCALE,
+		})
+	}
+	json["text"] = expression.Text
+	json["terms"] = terms
+	return json
+}
+
+func (restrain PropertyRestrain) ToJson() (json map[string]interface{}) {
+	json = make(map[string]interface{})
+	conditions := make([]interface{}, 0)
+	for _, condition := range restrain.Filter.Conditions {
+		conditions = append(conditions, map[string]interface{}{"property": condition.Property, "operator": condition.Operator, "values": condition.Values})
+	}
+	json["type"] = restrain.Type()
+	json["filter"] = restrain.Filter.Text
+	json["conditions"] = conditions
+	json["cards"] = len(restrain.Set.Ids)
+	json["range"] = restrain.Range
+	json["condition"] = restrain.Condition.ToJson()
+	return json
+}
+
 func (restrain RestrainGroup) ToJson() (json map[string]interface{}) {
 	json = make(map[string]interface{})
 	restrains := make([]interface{}, 0)
@@ -106,18 +158,20 @@ func (identifier *IdentifierWrapper) ToJson() (json map[string]interface{}) {
 	decks := make([]map[string]interface{}, 0)
 	tags := make([]map[string]interface{}, 0)
 	sets := make([]map[string]interface{}, 0)
-	for _, deck := range identifier.Decks {
+	current := identifier.Current()
+	for _, deck := range current.Decks {
 		decks = append(decks, deck.ToJson())
 	}
-	for _, tag := range identifier.Tags {
+	for _, tag := range current.Tags {
 		tags = append(tags, tag.ToJson())
 	}
-	for _, set := range identifier.CustomSets {
+	for _, set := range current.CustomSets {
 		sets = append(sets, SetToJson(set))
 	}
 	json["decks"] = decks
 	json["tags"] = tags
 	json["sets"] = sets
+	json["generation"] = current.Generation
 	return json
 }
 
@@ -172,6 +226,11 @@ func (answer *VerboseTagAnswer) ToJson() map[string]interface{} {
 func (answer *VerboseRestrainAnswer) ToJson() map[string]interface{} {
 	json := answer.restrain.ToJson()
 	json["value"] = answer.value
+	if len(answer.operands) == 2 {
+		json["value"] = float64(answer.value) / EXPRESSION_SCALE
+		json["left"].(map[string]interface{})["value"] = float64(answer.operands[0]) / EXPRESSION_SCALE
+		json["right"].(map[string]interface{})["value"] = float64(answer.operands[1]) / EXPRESSION_SCALE
+	}
 	json["is"] = answer.is
 	children := make([]interface{}, 0)
 	for _, child := range answer.children {
@@ -214,3 +273,284 @@ func (result *VerboseResult) ToJson() map[string]interface{} {
 
 	return json
 }
+
+// =========================
+// Diagnostic Area
+// =========================
+
+func (diagnostic *Diagnostic) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["severity"] = diagnostic.Severity
+	json["code"] = diagnostic.Code
+	json["file"] = diagnostic.File
+	json["line"] = diagnostic.Line
+	json["column"] = diagnostic.Column
+	json["endColumn"] = diagnostic.EndColumn
+	json["message"] = diagnostic.Message
+	if diagnostic.Node != nil {
+		json["node"] = map[string]interface{}{"type": diagnostic.Node.Type, "value": diagnostic.Node.Value}
+	}
+	if diagnostic.Importer != nil {
+		importedBy := make([]interface{}, 0)
+		for importer := diagnostic.Importer; importer != nil; importer = importer.Importer {
+			importedBy = append(importedBy, map[string]interface{}{"file": importer.File, "line": importer.Line})
+		}
+		json["importedBy"] = importedBy
+	}
+	if diagnostic.ExpandedFrom != nil {
+		expandedFrom := make([]interface{}, 0)
+		for use := diagnostic.ExpandedFrom; use != nil; use = use.ExpandedFrom {
+			expandedFrom = append(expandedFrom, map[string]interface{}{"file": use.File, "line": use.Line, "text": strings.TrimSpace(use.Text)})
+		}
+		json["expandedFrom"] = expandedFrom
+	}
+	return json
+}
+
+func (diagnostics Diagnostics) ToJson() []interface{} {
+	json := make([]interface{}, 0)
+	for _, diagnostic := range diagnostics {
+		json = append(json, diagnostic.ToJson())
+	}
+	return json
+}
+
+// CompileReportJson summarizes a reload or preview of the identifier together with its diagnostics.
+func (identifier *Identifier) CompileReportJson(ok bool, diagnostics Diagnostics) map[string]interface{} {
+	json := make(map[string]interface{})
+	json["identifier"] = identifier.Name
+	json["ok"] = ok && !diagnostics.HasError()
+	json["decks"] = len(identifier.Decks)
+	json["tags"] = len(identifier.Tags)
+	json["globalTags"] = len(identifier.GlobalTags)
+	json["sets"] = len(identifier.CustomSets)
+	json["generation"] = identifier.Generation
+	json["diagnostics"] = diagnostics.ToJson()
+	return json
+}
+
+// Candidate#ToJson will remove the deck details, only return the name.
+func (candidate *Candidate) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["deck"] = candidate.Deck.Name
+	json["priority"] = candidate.Deck.Priority
+	json["matched"] = candidate.Matched
+	json["passed"] = candidate.Passed
+	json["total"] = candidate.Total
+	json["score"] = candidate.Score
+	return json
+}
+
+func CandidatesToJson(candidates []Candidate) []interface{} {
+	json := make([]interface{}, 0)
+	for _, candidate := range candidates {
+		json = append(json, candidate.ToJson())
+	}
+	return json
+}
+
+func (result *BatchResult) ToJson() map[string]interface{} {
+	if len(result.Error) > 0 {
+		return map[string]interface{}{"key": result.Key, "generation": result.Generation, "error": result.Error}
+	}
+	json := result.Result.ToJson()
+	json["key"] = result.Key
+	json["generation"] = result.Generation
+	return json
+}
+
+func (outcome *DeckTestOutcome) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["file"] = outcome.Test.Path
+	json["passed"] = outcome.Passed()
+	json["deck"] = outcome.Deck
+	json["tag"] = outcome.Tags
+	json["expect"] = map[string]interface{}{"deck": outcome.Test.ExpectDeck, "tag": outcome.Test.ExpectTags, "noTag": outcome.Test.RefuseTags}
+	if !outcome.Passed() {
+		json["failures"] = outcome.Failures.ToJson()
+		json["verbose"] = outcome.Verbose
+	}
+	return json
+}
+
+func (report *DeckTestReport) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["identifier"] = report.Identifier
+	json["generation"] = report.Generation
+	json["total"] = len(report.Outcomes)
+	json["passed"] = len(report.Outcomes) - report.Failed
+	json["failed"] = report.Failed
+	outcomes := make([]interface{}, 0)
+	for index := range report.Outcomes {
+		outcomes = append(outcomes, report.Outcomes[index].ToJson())
+	}
+	json["outcomes"] = outcomes
+	json["diagnostics"] = report.Diagnostics.ToJson()
+	return json
+}
+
+func (change *DeckChange) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["key"] = change.Key
+	json["old"] = map[string]interface{}{"deck": change.OldDeck, "tag": change.OldTags}
+	json["new"] = map[string]interface{}{"deck": change.NewDeck, "tag": change.NewTags}
+	return json
+}
+
+func (report *DiffReport) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["old"] = map[string]interface{}{"identifier": report.Old.Name, "generation": report.Old.Generation}
+	json["new"] = map[string]interface{}{"identifier": report.New.Name, "generation": report.New.Generation}
+	json["total"] = report.Total
+	json["changed"] = len(report.Changes)
+	transitions := make([]interface{}, 0)
+	for _, transition := range report.Transitions {
+		transitions = append(transitions, map[string]interface{}{"from": transition.From, "to": transition.To, "count": transition.Count, "keys": transition.Keys})
+	}
+	json["transitions"] = transitions
+	changes := make([]interface{}, 0)
+	for index := range report.Changes {
+		changes = append(changes, report.Changes[index].ToJson())
+	}
+	json["changes"] = changes
+	json["errors"] = report.Errors
+	return json
+}
+
+func (window *ShareWindow) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["from"] = window.From.Format(time.RFC3339)
+	json["to"] = window.To.Format(time.RFC3339)
+	json["total"] = window.Total
+	entries := make([]interface{}, 0)
+	for _, entry := range window.Entries {
+		entries = append(entries, map[string]interface{}{"name": entry.Name, "count": entry.Count, "share": entry.Share})
+	}
+	json["entries"] = entries
+	return json
+}
+
+func ShareWindowsToJson(query ShareQuery, windows []ShareWindow) map[string]interface{} {
+	json := make(map[string]interface{})
+	json["identifier"] = query.Identifier
+	json["source"] = query.Source
+	json["from"] = query.From.Format(time.RFC3339)
+	json["to"] = query.To.Format(time.RFC3339)
+	answer := make([]interface{}, 0)
+	for index := range windows {
+		answer = append(answer, windows[index].ToJson())
+	}
+	json["windows"] = answer
+	return json
+}
+
+func (cluster *Cluster) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["keys"] = cluster.Keys
+	cards := make([]interface{}, 0)
+	for _, card := range cluster.Cards {
+		cards = append(cards, map[string]interface{}{"id": card.Id, "name": card.Name, "share": card.Share, "background": card.Background, "count": card.Count})
+	}
+	json["cards"] = cards
+	sets := make([]interface{}, 0)
+	for _, set := range cluster.Sets {
+		sets = append(sets, map[string]interface{}{"name": set.Name, "minimum": set.Minimum, "mean": set.Mean, "background": set.Background})
+	}
+	json["sets"] = sets
+	json["draft"] = cluster.Draft
+	json["draftName"] = cluster.DraftName
+	json["matched"] = cluster.Matched
+	return json
+}
+
+func (report *ClusterReport) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["identifier"] = report.Identifier
+	json["generation"] = report.Generation
+	json["total"] = report.Total
+	json["unknown"] = report.Unknown
+	clusters := make([]interface{}, 0)
+	for index := range report.Clusters {
+		clusters = append(clusters, report.Clusters[index].ToJson())
+	}
+	json["clusters"] = clusters
+	json["diagnostics"] = report.Diagnostics.ToJson()
+	return json
+}
+
+func (score TuneScore) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["truePositive"] = score.TruePositive
+	json["falsePositive"] = score.FalsePositive
+	json["falseNegative"] = score.FalseNegative
+	json["trueNegative"] = score.TrueNegative
+	json["precision"] = score.Precision()
+	json["recall"] = score.Recall()
+	json["f1"] = score.F1()
+	json["separation"] = score.Separation()
+	return json
+}
+
+func (tuned *TuneRestrain) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["path"] = tunePathString(tuned.Path)
+	json["target"] = tuned.Target
+	json["restrain"] = tuned.Restrain.ToJson()
+	distribution := make([]interface{}, 0)
+	for _, value := range tuned.Distribution {
+		distribution = append(distribution, map[string]interface{}{"value": value.Value, "positive": value.Positive, "negative": value.Negative})
+	}
+	json["distribution"] = distribution
+	json["current"] = map[string]interface{}{"condition": conditionText(tuned.Current), "score": tuned.CurrentScore.ToJson()}
+	json["suggested"] = map[string]interface{}{"condition": conditionText(tuned.Suggested), "score": tuned.SuggestScore.ToJson()}
+	json["changed"] = tuned.Changed
+	return json
+}
+
+func (report *TuneReport) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["identifier"] = report.Identifier
+	json["generation"] = report.Generation
+	json["deck"] = report.Deck
+	json["positive"] = report.Positive
+	json["negative"] = report.Negative
+	restrains := make([]interface{}, 0)
+	for index := range report.Restrains {
+		restrains = append(restrains, report.Restrains[index].ToJson())
+	}
+	json["restrains"] = restrains
+	json["current"] = report.Current.ToJson()
+	json["suggested"] = report.Suggested.ToJson()
+	json["diagnostics"] = report.Diagnostics.ToJson()
+	return json
+}
+
+func (entry *CoverageEntry) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["kind"] = entry.Kind
+	json["name"] = entry.Name
+	if entry.Kind == COVERAGE_RESTRAIN {
+		json["owner"] = entry.Owner
+		json["decisive"] = entry.Decisive
+	}
+	json["file"] = entry.File
+	json["line"] = entry.Line
+	json["text"] = entry.Text
+	json["evaluated"] = entry.Evaluated
+	json["passed"] = entry.Passed
+	return json
+}
+
+func (report *CoverageReport) ToJson() map[string]interface{} {
+	json := make(map[string]interface{})
+	json["identifier"] = report.Identifier
+	json["generation"] = report.Generation
+	json["total"] = report.Total
+	entries := make([]interface{}, 0)
+	for index := range report.Entries {
+		entries = append(entries, report.Entries[index].ToJson())
+	}
+	json["entries"] = entries
+	json["diagnostics"] = report.Diagnostics.ToJson()
+	return json
+}
@@ -0,0 +1,540 @@
+package ygopro_deck_identifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const LSP_COMPLETION_LIMIT = 50
+const LSP_HOVER_SET_PREVIEW = 10
+
+// LSP constants, see the Language Server Protocol specification.
+const lspSeverityError = 1
+const lspSeverityWarning = 2
+const lspSeverityInformation = 3
+const lspCompletionKindClass = 7
+const lspCompletionKindValue = 12
+const lspTextDocumentSyncFull = 1
+const lspMethodNotFound = -32601
+
+type lspMessage struct {
+	JsonRPC string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type lspTextDocument struct {
+	Uri  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	Position       lspPosition     `json:"position"`
+	Text           *string         `json:"text"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDocument struct {
+	filename   string
+	text       string
+	identifier *Identifier
+	root       *astNode
+}
+
+type languageServer struct {
+	reader    *bufio.Reader
+	writer    io.Writer
+	lock      sync.Mutex
+	documents map[string]*lspDocument
+}
+
+// StartLanguageServer serves the Language Server Protocol for .deckdef files until the client exits.
+func StartLanguageServer(input io.Reader, output io.Writer) {
+	server := &languageServer{reader: bufio.NewReader(input), writer: output, documents: make(map[string]*lspDocument)}
+	for {
+		message, err := server.read()
+		if err != nil {
+			if err != io.EOF {
+				Logger.Errorf("Language server failed to read message: %v", err)
+			}
+			return
+		}
+		if message.Method == "exit" {
+			return
+		}
+		server.handle(message)
+	}
+}
+
+// ================ Transport =================
+
+func (server *languageServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := server.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, _ = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(server.reader, body); err != nil {
+		return nil, err
+	}
+	message := new(lspMessage)
+	if err := json.Unmarshal(body, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (server *languageServer) write(message map[string]interface{}) {
+	message["jsonrpc"] = "2.0"
+	body, err := json.Marshal(message)
+	if err != nil {
+		Logger.Errorf("Language server failed to encode message: %v", err)
+		return
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	fmt.Fprintf(server.writer, "Content-Length: %d\r\n\r\n", len(body))
+	server.writer.Write(body)
+}
+
+func (server *languageServer) reply(message *lspMessage, result interface{}) {
+	server.write(map[string]interface{}{"id": message.Id, "result": result})
+}
+
+func (server *languageServer) notify(method string, params interface{}) {
+	server.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// ================ Dispatch =================
+
+func (server *languageServer) handle(message *lspMessage) {
+	params := lspDocumentParams{}
+	if len(message.Params) > 0 {
+		json.Unmarshal(message.Params, &params)
+	}
+	uri := params.TextDocument.Uri
+	switch message.Method {
+	case "initialize":
+		server.reply(message, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    lspTextDocumentSyncFull,
+					"save":      map[string]interface{}{"includeText": true},
+				},
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"[", ":"}},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+			},
+			"serverInfo": map[string]interface{}{"name": "ygopro-deck-identifier"},
+		})
+	case "shutdown":
+		server.reply(message, nil)
+	case "textDocument/didOpen":
+		server.documents[uri] = &lspDocument{filename: uriToFilename(uri), text: params.TextDocument.Text}
+		server.publishDiagnostics(uri)
+	case "textDocument/didChange":
+		if document, ok := server.documents[uri]; ok && len(params.ContentChanges) > 0 {
+			document.text = params.ContentChanges[len(params.ContentChanges)-1].Text
+			document.identifier = nil
+		}
+	case "textDocument/didSave":
+		if document, ok := server.documents[uri]; ok {
+			if params.Text != nil {
+				document.text = *params.Text
+			}
+			document.identifier = nil
+			server.publishDiagnostics(uri)
+		}
+	case "textDocument/didClose":
+		delete(server.documents, uri)
+		server.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []interface{}{}})
+	case "textDocument/completion":
+		server.reply(message, server.complete(uri, params.Position))
+	case "textDocument/hover":
+		server.reply(message, server.hover(uri, params.Position))
+	case "textDocument/definition":
+		server.reply(message, server.definition(uri, params.Position))
+	default:
+		if message.Id != nil {
+			server.write(map[string]interface{}{"id": message.Id, "error": map[string]interface{}{"code": lspMethodNotFound, "message": "Method not found: " + message.Method}})
+		}
+	}
+}
+
+// ================ Compilation =================
+
+// owner finds the identifier whose definition directory contains the file.
+func (server *languageServer) owner(filename string) *IdentifierWrapper {
+	for _, wrapper := range GlobalIdentifierMap {
+		if directory, err := filepath.Abs(wrapper.GetPath()); err == nil && strings.HasPrefix(filename, directory+string(filepath.Separator)) {
+			return wrapper
+		}
+	}
+	return nil
+}
+
+// compile prepares the document against its owner identifier, so sets and tags from sibling files resolve.
+func (server *languageServer) compile(uri string) *lspDocument {
+	document, ok := server.documents[uri]
+	if !ok {
+		return nil
+	}
+	if document.identifier != nil {
+		return document
+	}
+	compiler := new(Compiler)
+	compiler.CompileNamedString(document.text, document.filename)
+	identifier := NewIdentifier("lsp")
+	identifier.clear()
+	compiler.ExpandImportedTemplates(identifier.registerImports(compiler.Root, filepath.Dir(document.filename), []string{document.filename}))
+	identifier.Diagnostics = append(identifier.Diagnostics, compiler.Diagnostics...)
+	identifier.prototype.registerNode(compiler.Root)
+	if owner := server.owner(document.filename); owner != nil {
+		identifier.Ready(owner.Current())
+	} else {
+		identifier.Ready(nil)
+	}
+	document.identifier = identifier
+	document.root = compiler.Root
+	return document
+}
+
+func (server *languageServer) publishDiagnostics(uri string) {
+	document := server.compile(uri)
+	if document == nil {
+		return
+	}
+	lines := strings.Split(document.text, "\n")
+	diagnostics := make([]interface{}, 0)
+	for _, diagnostic := range document.identifier.Diagnostics {
+		if diagnostic.File != document.filename || diagnostic.Line < 1 || diagnostic.Line > len(lines) {
+			continue
+		}
+		line := lines[diagnostic.Line-1]
+		severity := lspSeverityInformation
+		switch diagnostic.Severity {
+		case DIAGNOSTIC_ERROR:
+			severity = lspSeverityError
+		case DIAGNOSTIC_WARNING:
+			severity = lspSeverityWarning
+		}
+		diagnostics = append(diagnostics, map[string]interface{}{
+			"range":    lspRange(diagnostic.Line-1, utf16Column(line, diagnostic.Column-1), utf16Column(line, diagnostic.EndColumn-1)),
+			"severity": severity,
+			"code":     diagnostic.Code,
+			"source":   "deckdef",
+			"message":  diagnostic.Message,
+		})
+	}
+	server.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+// ================ Completion =================
+
+func (server *languageServer) complete(uri string, position lspPosition) interface{} {
+	document := server.compile(uri)
+	if document == nil {
+		return []interface{}{}
+	}
+	line := documentLine(document.text, position.Line)
+	before := []rune(line)[:runeColumn(line, position.Character)]
+	prefix := string(before)
+	content := strings.TrimSpace(line)
+	lineType, _ := new(Compiler).checkLineType(&content)
+	setMode := lineType == "set" || lineType == "series" || lineType == "inner set"
+	if open := strings.LastIndex(prefix, "["); open >= 0 && !strings.Contains(prefix[open:], "]") {
+		prefix = prefix[open+1:]
+		setMode = true
+	} else {
+		if index := strings.LastIndexAny(prefix, ":!()&|"); index >= 0 {
+			prefix = prefix[index+1:]
+		}
+		prefix = strings.TrimLeft(prefix, " \t")
+	}
+	start := len(before) - len([]rune(prefix))
+	editRange := lspRange(position.Line, utf16Column(line, start), position.Character)
+
+	names := make(map[string]string)
+	if lineType == "setcode" || lineType == "set code" || lineType == "archetype" {
+		for _, set := range document.identifier.BindingEnvironment.Sets {
+			names[set.Name] = "0x" + strconv.FormatInt(set.Code, 16)
+		}
+	} else if setMode {
+		for _, set := range document.identifier.BindingEnvironment.Sets {
+			names[set.Name] = strconv.Itoa(len(set.Ids)) + " cards"
+		}
+		if owner := server.owner(document.filename); owner != nil {
+			for _, set := range owner.Current().CustomSets {
+				names[set.Name] = strconv.Itoa(len(set.Ids)) + " cards"
+			}
+		}
+		for _, set := range document.identifier.CustomSets {
+			names[set.Name] = strconv.Itoa(len(set.Ids)) + " cards"
+		}
+	} else {
+		for _, card := range document.identifier.BindingEnvironment.Cards {
+			if !card.IsAlias() {
+				names[card.Name] = strconv.Itoa(card.Id)
+			}
+		}
+	}
+	labels := make([]string, 0)
+	for name := range names {
+		if strings.Contains(name, prefix) {
+			labels = append(labels, name)
+		}
+	}
+	sort.Strings(labels)
+	incomplete := len(labels) > LSP_COMPLETION_LIMIT
+	if incomplete {
+		labels = labels[:LSP_COMPLETION_LIMIT]
+	}
+	kind := lspCompletionKindValue
+	if setMode {
+		kind = lspCompletionKindClass
+	}
+	items := make([]interface{}, 0)
+	for _, label := range labels {
+		items = append(items, map[string]interface{}{
+			"label":    label,
+			"kind":     kind,
+			"detail":   names[label],
+			"textEdit": map[string]interface{}{"range": editRange, "newText": label},
+		})
+	}
+	return map[string]interface{}{"isIncomplete": incomplete, "items": items}
+}
+
+// ================ Hover & Definition =================
+
+// nodeAt returns the most specific named node under the cursor, along with its parent.
+func (server *languageServer) nodeAt(document *lspDocument, position lspPosition) (*astNode, *astNode) {
+	line := documentLine(document.text, position.Line)
+	column := runeColumn(line, position.Character) + 1
+	var found, foundParent *astNode
+	width := -1
+	var walk func(node, parent *astNode)
+	walk = func(node, parent *astNode) {
+		if node.Origin != nil && node.Origin.Line == position.Line+1 && len(node.Value) > 0 {
+			start, end := node.Origin.span(node.Value)
+			if column >= start && column <= end && (width < 0 || end-start < width) {
+				found, foundParent, width = node, parent, end-start
+			}
+		}
+		for _, child := range node.Children {
+			walk(child, node)
+		}
+	}
+	walk(document.root, nil)
+	return found, foundParent
+}
+
+// referenceOf tells which kind of definition a node refers to: "card", "set" or "tag".
+func referenceOf(node, parent *astNode) (string, string) {
+	switch node.Type {
+	case "target":
+		if parent != nil && parent.Value == "set" {
+			return "set", strings.Trim(node.Value, "[]")
+		}
+		return "card", node.Value
+	case "set card":
+		return "card", node.Value
+	case "inner set", "set":
+		return "set", strings.Trim(node.Value, "[]")
+	case "tag", "check tag", "force tag", "refuse tag":
+		return "tag", node.Value
+	}
+	return "", ""
+}
+
+func (server *languageServer) hover(uri string, position lspPosition) interface{} {
+	document := server.compile(uri)
+	if document == nil {
+		return nil
+	}
+	node, parent := server.nodeAt(document, position)
+	if node == nil {
+		return nil
+	}
+	kind, name := referenceOf(node, parent)
+	var text string
+	switch kind {
+	case "card":
+		if card, ok := transformCard(name, document.identifier.BindingEnvironment); ok {
+			text = fmt.Sprintf("**%v** (%d)\n\n%v", card.Name, card.Id, card.Desc)
+		}
+	case "set":
+		if set, ok := server.searchSet(document, name); ok {
+			text = fmt.Sprintf("**[%v]** %d cards", set.Name, len(set.Ids))
+			for index, id := range set.Ids {
+				if index >= LSP_HOVER_SET_PREVIEW {
+					text += "\n- ..."
+					break
+				}
+				if card, ok := document.identifier.BindingEnvironment.GetCard(id); ok {
+					text += fmt.Sprintf("\n- %v (%d)", card.Name, card.Id)
+				} else {
+					text += fmt.Sprintf("\n- %d", id)
+				}
+			}
+		}
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"contents": map[string]interface{}{"kind": "markdown", "value": text}}
+}
+
+func (server *languageServer) searchSet(document *lspDocument, name string) (ygopro_data.Set, bool) {
+	if set, ok := document.identifier.searchNamedSet(name); ok {
+		return set, true
+	}
+	if owner := server.owner(document.filename); owner != nil {
+		return owner.Current().lookupNamedSet(name)
+	}
+	return ygopro_data.Set{}, false
+}
+
+func (server *languageServer) definition(uri string, position lspPosition) interface{} {
+	document := server.compile(uri)
+	if document == nil {
+		return nil
+	}
+	node, parent := server.nodeAt(document, position)
+	if node == nil {
+		return nil
+	}
+	kind, name := referenceOf(node, parent)
+	if kind != "set" && kind != "tag" {
+		return nil
+	}
+	locations := make([]interface{}, 0)
+	for _, root := range server.workspaceRoots(document) {
+		for _, child := range root.Children {
+			if child.Type == kind && strings.Trim(child.Value, "[]") == name && child.Origin != nil {
+				line := child.Origin.Text
+				start, end := child.Origin.span(child.Value)
+				locations = append(locations, map[string]interface{}{
+					"uri":   filenameToUri(child.Origin.File),
+					"range": lspRange(child.Origin.Line-1, utf16Column(line, start-1), utf16Column(line, end-1)),
+				})
+			}
+		}
+	}
+	return locations
+}
+
+// workspaceRoots compiles every definition file visible from the document: open documents win over disk.
+func (server *languageServer) workspaceRoots(document *lspDocument) []*astNode {
+	roots := []*astNode{document.root}
+	seen := map[string]bool{document.filename: true}
+	for uri, other := range server.documents {
+		if !seen[other.filename] {
+			seen[other.filename] = true
+			if compiled := server.compile(uri); compiled != nil {
+				roots = append(roots, compiled.root)
+			}
+		}
+	}
+	if owner := server.owner(document.filename); owner != nil {
+		filepath.Walk(owner.GetPath(), func(path string, info os.FileInfo, err error) error {
+			if absolute, err := filepath.Abs(path); err == nil && strings.HasSuffix(path, ".deckdef") && !seen[absolute] {
+				seen[absolute] = true
+				compiler := new(Compiler)
+				compiler.CompileFile(absolute)
+				roots = append(roots, compiler.Root)
+			}
+			return nil
+		})
+	}
+	return roots
+}
+
+// ================ Position Helpers =================
+
+func lspRange(line, start, end int) map[string]interface{} {
+	return map[string]interface{}{
+		"start": map[string]interface{}{"line": line, "character": start},
+		"end":   map[string]interface{}{"line": line, "character": end},
+	}
+}
+
+func documentLine(text string, line int) string {
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+	return strings.TrimSuffix(lines[line], "\r")
+}
+
+// utf16Column converts a character offset into the UTF-16 offset LSP clients count in.
+func utf16Column(line string, column int) int {
+	runes := []rune(line)
+	if column > len(runes) {
+		column = len(runes)
+	}
+	if column < 0 {
+		column = 0
+	}
+	return len(utf16.Encode(runes[:column]))
+}
+
+// runeColumn converts a UTF-16 offset from the client back into a character offset.
+func runeColumn(line string, character int) int {
+	units := 0
+	for index, r := range []rune(line) {
+		if units >= character {
+			return index
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len([]rune(line))
+}
+
+func uriToFilename(uri string) string {
+	if parsed, err := url.Parse(uri); err == nil && parsed.Scheme == "file" {
+		return filepath.FromSlash(parsed.Path)
+	}
+	return uri
+}
+
+func filenameToUri(filename string) string {
+	if absolute, err := filepath.Abs(filename); err == nil {
+		filename = absolute
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
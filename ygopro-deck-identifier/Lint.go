@@ -0,0 +1,333 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Lint looks over a prepared identifier for definitions which can't work the way they read: decks a
+// higher priority deck always takes first, decks whose order is left to chance, duplicated names, sets
+// nothing refers to and tags nothing attaches. Definitions imported from a library are only checked
+// for what this identifier does with them.
+func (identifier *Identifier) Lint() Diagnostics {
+	diagnostics := make(Diagnostics, 0)
+	identifier.lintDuplicates(&diagnostics)
+	identifier.lintShadows(&diagnostics)
+	identifier.lintUnusedSets(&diagnostics)
+	identifier.lintUnattachedTags(&diagnostics)
+	return diagnostics
+}
+
+func (identifier *Identifier) lintDuplicates(diagnostics *Diagnostics) {
+	decks := make(map[string]*astNode)
+	for _, node := range identifier.prototype.decks {
+		if first, ok := decks[node.Value]; ok {
+			diagnostics.report(DIAGNOSTIC_WARNING, "duplicate-deck", node, nil, "Deck %v is already defined at %v", node.Value, originMessageLoggerHead(first))
+		} else {
+			decks[node.Value] = node
+		}
+	}
+	tags := make(map[string]*astNode)
+	for _, node := range identifier.prototype.tags {
+		if first, ok := tags[node.Value]; ok {
+			diagnostics.report(DIAGNOSTIC_WARNING, "duplicate-tag", node, nil, "Tag %v is already defined at %v", node.Value, originMessageLoggerHead(first))
+		} else {
+			tags[node.Value] = node
+		}
+	}
+}
+
+// lintShadows compares every deck with the decks recognized before it. A deck is shadowed when its own
+// restrains imply all the restrains of an earlier deck with a higher priority: whatever it matches, the
+// earlier one matched already. Equal priorities are ordered arbitrarily, so there any overlap is reported.
+func (identifier *Identifier) lintShadows(diagnostics *Diagnostics) {
+	witnesses := make([]ygopro_data.Deck, len(identifier.Decks))
+	for index, deck := range identifier.Decks {
+		witnesses[index] = witnessDeck(deck.Restrains)
+	}
+	for later, deck := range identifier.Decks {
+		if len(deck.Restrains) == 0 || deck.node == nil {
+			continue
+		}
+		facts := restrainFacts(deck.Restrains)
+		conflicts := make([]string, 0)
+		for earlier, other := range identifier.Decks[:later] {
+			if len(other.Restrains) == 0 {
+				continue
+			}
+			implied := restrainsImplied(other.Restrains, facts)
+			if other.Priority > deck.Priority {
+				if implied {
+					diagnostics.report(DIAGNOSTIC_WARNING, "shadowed-deck", deck.node, nil, "Deck %v [%d] is never recognized, every deck it matches is taken by %v [%d] first", deck.Name, deck.Priority, other.Name, other.Priority)
+					conflicts = nil
+					break
+				}
+			} else if other.Priority == deck.Priority && other.Name != deck.Name && (implied || restrainsImplied(deck.Restrains, restrainFacts(other.Restrains)) ||
+				other.Judge(witnesses[later]) || deck.Judge(witnesses[earlier])) {
+				conflicts = append(conflicts, other.Name)
+			}
+		}
+		if len(conflicts) > 0 {
+			diagnostics.report(DIAGNOSTIC_WARNING, "priority-conflict", deck.node, nil, "Deck %v shares priority %d with %v and they can match the same deck, which one wins is arbitrary", deck.Name, deck.Priority, strings.Join(conflicts, ", "))
+		}
+	}
+}
+
+func (identifier *Identifier) lintUnusedSets(diagnostics *Diagnostics) {
+	used := make(map[string]bool)
+	for _, roots := range [][]*astNode{identifier.prototype.decks, identifier.prototype.tags, identifier.prototype.sets} {
+		for _, node := range roots {
+			collectSetReferences(node, used)
+		}
+	}
+	for _, node := range identifier.prototype.sets {
+		if !used[node.Value] && (node.Origin == nil || node.Origin.Importer == nil) {
+			diagnostics.report(DIAGNOSTIC_INFORMATION, "unused-set", node, nil, "Set %v is never used", node.Value)
+		}
+	}
+}
+
+func collectSetReferences(node *astNode, used map[string]bool) {
+	switch {
+	case node.Type == "inner set":
+		if matches := setIdentifierReg.FindStringSubmatch(node.Value); matches != nil {
+			used[matches[1]] = true
+		} else {
+			used[node.Value] = true
+		}
+	case node.Type == "restrain" && node.Value == "set":
+		for _, child := range node.Children {
+			if child.Type == "target" {
+				used[child.Value] = true
+			}
+		}
+	case node.Type == "left" || node.Type == "right":
+		for _, matches := range setIdentifierReg.FindAllStringSubmatch(node.Value, -1) {
+			used[matches[1]] = true
+		}
+	}
+	for _, child := range node.Children {
+		collectSetReferences(child, used)
+	}
+}
+
+// lintUnattachedTags reports top level tags which are not global, so only come into play when a deck
+// names them, and no deck does.
+func (identifier *Identifier) lintUnattachedTags(diagnostics *Diagnostics) {
+	named := make(map[string]bool)
+	for _, node := range identifier.prototype.decks {
+		for _, child := range node.Children {
+			if child.Type == "check tag" || child.Type == "force tag" || child.Type == "refuse tag" {
+				named[child.Value] = true
+			}
+		}
+	}
+	for _, tag := range identifier.Tags {
+		if tag.Is("global") || named[tag.Name] || tag.node == nil || (tag.node.Origin != nil && tag.node.Origin.Importer != nil) {
+			continue
+		}
+		diagnostics.report(DIAGNOSTIC_WARNING, "unattached-tag", tag.node, nil, "Tag %v is neither global nor named by any deck", tag.Name)
+	}
+}
+
+// ======================
+// Implication
+// ======================
+
+// restrainFacts lists what a passing deck is known to satisfy, taking the children of "and" groups apart.
+func restrainFacts(restrains []Restrain) []Restrain {
+	facts := make([]Restrain, 0)
+	for _, restrain := range restrains {
+		if group, ok := restrain.(RestrainGroup); ok && group.Condition.operator == "and" && group.Condition.number == len(group.Restrains) {
+			facts = append(facts, restrainFacts(group.Restrains)...)
+		} else {
+			facts = append(facts, restrain)
+		}
+	}
+	return facts
+}
+
+func restrainsImplied(restrains []Restrain, facts []Restrain) bool {
+	for _, restrain := range restrains {
+		if !restrainImplied(restrain, facts) {
+			return false
+		}
+	}
+	return true
+}
+
+// restrainImplied tells whether every deck satisfying the facts surely passes the restrain. It only knows
+// the common cases, a false answer means "not proven".
+func restrainImplied(restrain Restrain, facts []Restrain) bool {
+	if group, ok := restrain.(RestrainGroup); ok {
+		switch {
+		case group.Condition.operator == "and" && group.Condition.number == len(group.Restrains):
+			return restrainsImplied(group.Restrains, facts)
+		case group.Condition.operator == "or" || group.Condition.operator == "|" || group.Condition.operator == "||":
+			for _, child := range group.Restrains {
+				if restrainImplied(child, facts) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	for _, fact := range facts {
+		if reflect.DeepEqual(fact, restrain) || countImplies(fact, restrain) {
+			return true
+		}
+	}
+	return false
+}
+
+// countImplies compares two card counting restrains. Counting the same cards, the passing values of the fact
+// must all pass the restrain; counting a part of the restrain's cards, a lower bound carries over.
+func countImplies(fact Restrain, restrain Restrain) bool {
+	factIds, factRange, factCondition, ok := countingRestrain(fact)
+	if !ok {
+		return false
+	}
+	ids, targetRange, condition, ok := countingRestrain(restrain)
+	if !ok || !rangeWithin(factRange, targetRange) {
+		return false
+	}
+	factLow, factHigh, ok := conditionInterval(factCondition)
+	if !ok {
+		return false
+	}
+	low, high, ok := conditionInterval(condition)
+	if !ok {
+		return false
+	}
+	if rangeName(factRange) == rangeName(targetRange) && sameIds(factIds, ids) {
+		return factLow >= low && factHigh <= high
+	}
+	if high != math.MaxInt32 || factLow < low {
+		return false
+	}
+	included := uniqueIds(ids)
+	counted := make(map[int]bool)
+	for _, id := range factIds {
+		if !included[id] || counted[id] {
+			return false
+		}
+		counted[id] = true
+	}
+	return true
+}
+
+func countingRestrain(restrain Restrain) ([]int, string, Condition, bool) {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		return []int{restrain.Id}, restrain.Range, restrain.Condition, true
+	case SetRestrain:
+		return restrain.Set.Ids, restrain.Range, restrain.Condition, true
+	case PropertyRestrain:
+		return restrain.Set.Ids, restrain.Range, restrain.Condition, true
+	}
+	return nil, "", Condition{}, false
+}
+
+func uniqueIds(ids []int) map[int]bool {
+	unique := make(map[int]bool)
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
+
+// sameIds tells whether both lists count the same cards the same number of times.
+func sameIds(left []int, right []int) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	equal := true
+	for index := range left {
+		if left[index] != right[index] {
+			equal = false
+			break
+		}
+	}
+	if equal {
+		return true
+	}
+	counts := make(map[int]int)
+	for _, id := range left {
+		counts[id] += 1
+	}
+	for _, id := range right {
+		counts[id] -= 1
+		if counts[id] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionInterval gives the passing counts of a condition as [low, high], high being MaxInt32 when unbounded.
+func conditionInterval(condition Condition) (int, int, bool) {
+	switch condition.operator {
+	case ">":
+		return condition.number + 1, math.MaxInt32, true
+	case ">=":
+		return condition.number, math.MaxInt32, true
+	case "<":
+		return 0, condition.number - 1, true
+	case "<=":
+		return 0, condition.number, true
+	case "=", "==":
+		return condition.number, condition.number, true
+	}
+	return 0, 0, false
+}
+
+// rangeName folds the spellings GetDeckTargetClassifiedRange accepts.
+func rangeName(targetRange string) string {
+	switch targetRange {
+	case "main", "side":
+		return targetRange
+	case "ex", "extra":
+		return "ex"
+	case "ori", "origin":
+		return "ori"
+	}
+	return "cards"
+}
+
+// rangeWithin tells whether every card of the inner range is also a card of the outer one.
+func rangeWithin(inner, outer string) bool {
+	inner, outer = rangeName(inner), rangeName(outer)
+	switch outer {
+	case inner, "cards":
+		return true
+	case "ori":
+		return inner == "main" || inner == "ex"
+	}
+	return false
+}
+
+// witnessDeck builds about the smallest deck the restrains ask for: the lower bound of every counting fact,
+// filled with the first card it counts. It passes the restrains as long as they only ask for cards.
+func witnessDeck(restrains []Restrain) ygopro_data.Deck {
+	deck := ygopro_data.Deck{}
+	for _, fact := range restrainFacts(restrains) {
+		ids, targetRange, condition, ok := countingRestrain(fact)
+		low, _, bounded := conditionInterval(condition)
+		if !ok || !bounded || len(ids) == 0 {
+			continue
+		}
+		pack := &deck.Main
+		switch rangeName(targetRange) {
+		case "side":
+			pack = &deck.Side
+		case "ex":
+			pack = &deck.Ex
+		}
+		for i := 0; i < low; i++ {
+			*pack = append(*pack, ids[0])
+		}
+	}
+	return PrepareDeck(deck, false)
+}
@@ -0,0 +1,221 @@
+package ygopro_deck_identifier
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are written in the Prometheus text format by hand, the few kinds needed here don't pay for a client library.
+const METRIC_COUNTER = "counter"
+const METRIC_GAUGE = "gauge"
+const METRIC_HISTOGRAM = "histogram"
+
+const METRIC_REQUEST_DURATION = "ygopro_request_duration_seconds"
+const METRIC_RELOAD_DURATION = "ygopro_reload_duration_seconds"
+const METRIC_RELOADS = "ygopro_reloads_total"
+const METRIC_RELOAD_DIAGNOSTICS = "ygopro_reload_diagnostics"
+const METRIC_GENERATION = "ygopro_identifier_generation"
+const METRIC_RECOGNITIONS = "ygopro_recognitions_total"
+const METRIC_UNKNOWN_DECKS = "ygopro_unknown_decks_total"
+const METRIC_DECKS = "ygopro_recognized_decks_total"
+const METRIC_TAGS = "ygopro_recognized_tags_total"
+
+var metricDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricFamily struct {
+	name    string
+	kind    string
+	help    string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// metricSeries is one labeled value of a family. Histograms count every bucket on its own and add them up when written.
+type metricSeries struct {
+	labels string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+type MetricRegistry struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+var Metrics = newMetricRegistry()
+
+func newMetricRegistry() *MetricRegistry {
+	registry := &MetricRegistry{families: make(map[string]*metricFamily)}
+	registry.declare(METRIC_REQUEST_DURATION, METRIC_HISTOGRAM, "Time spent answering a request, by route and identifier.", metricDurationBuckets)
+	registry.declare(METRIC_RELOAD_DURATION, METRIC_HISTOGRAM, "Time spent compiling and publishing an identifier.", metricDurationBuckets)
+	registry.declare(METRIC_RELOADS, METRIC_COUNTER, "Reloads of an identifier, by result.", nil)
+	registry.declare(METRIC_RELOAD_DIAGNOSTICS, METRIC_GAUGE, "Diagnostics of the last reload of an identifier, by severity.", nil)
+	registry.declare(METRIC_GENERATION, METRIC_GAUGE, "Generation of the published version of an identifier.", nil)
+	registry.declare(METRIC_RECOGNITIONS, METRIC_COUNTER, "Decks recognized through the API.", nil)
+	registry.declare(METRIC_UNKNOWN_DECKS, METRIC_COUNTER, "Decks recognized as the configured unknown deck.", nil)
+	registry.declare(METRIC_DECKS, METRIC_COUNTER, "Deck names returned by the recognition.", nil)
+	registry.declare(METRIC_TAGS, METRIC_COUNTER, "Tag names returned by the recognition.", nil)
+	return registry
+}
+
+func (registry *MetricRegistry) declare(name string, kind string, help string, buckets []float64) {
+	registry.families[name] = &metricFamily{name: name, kind: kind, help: help, buckets: buckets, series: make(map[string]*metricSeries)}
+}
+
+// seriesOf finds the series of the label pairs, creating it on first use. The caller must hold the lock.
+func (registry *MetricRegistry) seriesOf(name string, labels []string) *metricSeries {
+	family := registry.families[name]
+	key := formatMetricLabels(labels)
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labels: key}
+		if family.kind == METRIC_HISTOGRAM {
+			series.counts = make([]uint64, len(family.buckets))
+		}
+		family.series[key] = series
+	}
+	return series
+}
+
+// Add increases a counter. Labels come as name, value pairs.
+func (registry *MetricRegistry) Add(name string, delta float64, labels ...string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.seriesOf(name, labels).value += delta
+}
+
+func (registry *MetricRegistry) Set(name string, value float64, labels ...string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.seriesOf(name, labels).value = value
+}
+
+// Observe puts a value in a histogram, value keeping the sum of the observations.
+func (registry *MetricRegistry) Observe(name string, observation float64, labels ...string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	series := registry.seriesOf(name, labels)
+	for index, bound := range registry.families[name].buckets {
+		if observation <= bound {
+			series.counts[index] += 1
+			break
+		}
+	}
+	series.count += 1
+	series.value += observation
+}
+
+// Expose writes every family with at least one series, families and series in name order. The text is rendered
+// under the lock and written after, so a slow reader doesn't hold up the requests being counted.
+func (registry *MetricRegistry) Expose(writer io.Writer) {
+	var buffer bytes.Buffer
+	registry.render(&buffer)
+	writer.Write(buffer.Bytes())
+}
+
+func (registry *MetricRegistry) render(writer io.Writer) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	names := make([]string, 0, len(registry.families))
+	for name := range registry.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := registry.families[name]
+		if len(family.series) == 0 {
+			continue
+		}
+		fmt.Fprintf(writer, "# HELP %v %v\n# TYPE %v %v\n", name, family.help, name, family.kind)
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			if family.kind != METRIC_HISTOGRAM {
+				fmt.Fprintf(writer, "%v%v %v\n", name, wrapMetricLabels(series.labels), formatMetricValue(series.value))
+				continue
+			}
+			cumulative := uint64(0)
+			for index, bound := range family.buckets {
+				cumulative += series.counts[index]
+				fmt.Fprintf(writer, "%v_bucket%v %d\n", name, wrapMetricLabels(joinMetricLabels(series.labels, "le=\""+formatMetricValue(bound)+"\"")), cumulative)
+			}
+			fmt.Fprintf(writer, "%v_bucket%v %d\n", name, wrapMetricLabels(joinMetricLabels(series.labels, "le=\"+Inf\"")), series.count)
+			fmt.Fprintf(writer, "%v_sum%v %v\n", name, wrapMetricLabels(series.labels), formatMetricValue(series.value))
+			fmt.Fprintf(writer, "%v_count%v %d\n", name, wrapMetricLabels(series.labels), series.count)
+		}
+	}
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricLabels(labels []string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for index := 0; index+1 < len(labels); index += 2 {
+		pairs = append(pairs, labels[index]+"=\""+metricLabelEscaper.Replace(labels[index+1])+"\"")
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinMetricLabels(labels string, pair string) string {
+	if len(labels) == 0 {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+func wrapMetricLabels(labels string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// ======================
+// Recording
+// ======================
+
+// recordRecognition counts what the API answered for one deck, deck being the name after affixes.
+func (registry *MetricRegistry) recordRecognition(identifier string, deck string, tags []string) {
+	registry.Add(METRIC_RECOGNITIONS, 1, "identifier", identifier)
+	registry.Add(METRIC_DECKS, 1, "identifier", identifier, "deck", deck)
+	if deck == Config.UnknownDeck {
+		registry.Add(METRIC_UNKNOWN_DECKS, 1, "identifier", identifier)
+	}
+	for _, tag := range tags {
+		if len(tag) > 0 {
+			registry.Add(METRIC_TAGS, 1, "identifier", identifier, "tag", tag)
+		}
+	}
+}
+
+func (registry *MetricRegistry) recordReload(identifier *Identifier, ok bool, diagnostics Diagnostics, elapsed time.Duration) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+	}
+	registry.Add(METRIC_RELOADS, 1, "identifier", identifier.Name, "result", result)
+	registry.Observe(METRIC_RELOAD_DURATION, elapsed.Seconds(), "identifier", identifier.Name)
+	registry.Set(METRIC_GENERATION, float64(identifier.Generation), "identifier", identifier.Name)
+	severities := map[string]int{DIAGNOSTIC_ERROR: 0, DIAGNOSTIC_WARNING: 0, DIAGNOSTIC_INFORMATION: 0}
+	for _, diagnostic := range diagnostics {
+		severities[diagnostic.Severity] += 1
+	}
+	for severity, count := range severities {
+		registry.Set(METRIC_RELOAD_DIAGNOSTICS, float64(count), "identifier", identifier.Name, "severity", severity)
+	}
+}
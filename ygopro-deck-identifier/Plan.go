@@ -0,0 +1,192 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+)
+
+// EvaluationPlan indexes the decks and global tags of an identifier by the cards their restrains need.
+// A classification only passes if every one of its restrains passes, so a single restrain which can't
+// pass without some card of a known list is enough to skip the classification for decks holding none
+// of them. Classifications without such a restrain are evaluated for every deck.
+// The restrains themselves are compiled into the card space of the plan, see Vector.go.
+type EvaluationPlan struct {
+	decks      []Deck
+	globalTags []Tag
+	deckIndex  planIndex
+	tagIndex   planIndex
+
+	space         *cardSpace
+	compiledDecks []compiledDeck
+	compiledTags  []compiledClassification
+}
+
+type compiledDeck struct {
+	classification compiledClassification
+	checkTags      []compiledClassification
+}
+
+type planIndex struct {
+	byCard map[int][]int
+	always []int
+	size   int
+}
+
+func newEvaluationPlan(identifier *Identifier) *EvaluationPlan {
+	plan := new(EvaluationPlan)
+	plan.decks = identifier.Decks
+	plan.globalTags = identifier.GlobalTags
+	classifications := make([]Classification, 0, len(plan.decks))
+	for _, deck := range plan.decks {
+		classifications = append(classifications, deck.Classification)
+	}
+	plan.deckIndex = newPlanIndex(classifications)
+	classifications = make([]Classification, 0, len(plan.globalTags))
+	for _, tag := range plan.globalTags {
+		classifications = append(classifications, tag.Classification)
+	}
+	plan.tagIndex = newPlanIndex(classifications)
+	plan.compile()
+	return plan
+}
+
+func (plan *EvaluationPlan) compile() {
+	plan.space = newCardSpace()
+	classifications := make([]Classification, 0)
+	for _, deck := range plan.decks {
+		classifications = append(classifications, deck.Classification)
+		for _, tag := range deck.CheckTags {
+			classifications = append(classifications, tag.Classification)
+		}
+	}
+	for _, tag := range plan.globalTags {
+		classifications = append(classifications, tag.Classification)
+	}
+	for _, classification := range classifications {
+		for _, restrain := range classification.Restrains {
+			plan.space.register(restrain)
+		}
+	}
+	plan.compiledDecks = make([]compiledDeck, 0, len(plan.decks))
+	for _, deck := range plan.decks {
+		compiled := compiledDeck{classification: plan.space.compileClassification(deck.Classification)}
+		for _, tag := range deck.CheckTags {
+			compiled.checkTags = append(compiled.checkTags, plan.space.compileClassification(tag.Classification))
+		}
+		plan.compiledDecks = append(plan.compiledDecks, compiled)
+	}
+	plan.compiledTags = make([]compiledClassification, 0, len(plan.globalTags))
+	for _, tag := range plan.globalTags {
+		plan.compiledTags = append(plan.compiledTags, plan.space.compileClassification(tag.Classification))
+	}
+}
+
+// recognize returns the matched deck type and the passed global tags, like the linear loops would.
+func (plan *EvaluationPlan) recognize(deck ygopro_data.Deck) (*Result, []Tag) {
+	vector := plan.space.vectorize(&deck)
+	defer plan.space.release(vector)
+	return plan.recognizeDeck(vector), plan.recognizeTags(vector)
+}
+
+func newPlanIndex(classifications []Classification) planIndex {
+	index := planIndex{byCard: make(map[int][]int), always: make([]int, 0), size: len(classifications)}
+	for position, classification := range classifications {
+		ids, ok := classification.requiredCards()
+		if !ok {
+			index.always = append(index.always, position)
+			continue
+		}
+		for _, id := range ids {
+			positions := index.byCard[id]
+			if len(positions) == 0 || positions[len(positions)-1] != position {
+				index.byCard[id] = append(positions, position)
+			}
+		}
+	}
+	return index
+}
+
+// candidates lists, in definition order, the classifications which may pass on the deck.
+func (index *planIndex) candidates(deck *ygopro_data.Deck) []int {
+	marked := make([]bool, index.size)
+	for _, position := range index.always {
+		marked[position] = true
+	}
+	for id := range deck.ClassifiedCards {
+		for _, position := range index.byCard[id] {
+			marked[position] = true
+		}
+	}
+	positions := make([]int, 0)
+	for position, is := range marked {
+		if is {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+func (plan *EvaluationPlan) recognizeDeck(vector *deckVector) *Result {
+	for _, position := range plan.deckIndex.candidates(vector.deck) {
+		compiled := plan.compiledDecks[position]
+		if compiled.classification.judge(vector) {
+			return plan.decks[position].result(func(index int) bool { return compiled.checkTags[index].judge(vector) })
+		}
+	}
+	return nil
+}
+
+func (plan *EvaluationPlan) recognizeTags(vector *deckVector) []Tag {
+	answer := make([]Tag, 0)
+	for _, position := range plan.tagIndex.candidates(vector.deck) {
+		if plan.compiledTags[position].judge(vector) {
+			answer = append(answer, plan.globalTags[position])
+		}
+	}
+	return answer
+}
+
+// requiredCards picks the smallest card list one of the restrains can't pass without.
+func (classification Classification) requiredCards() ([]int, bool) {
+	var best []int
+	found := false
+	for _, restrain := range classification.Restrains {
+		if ids, ok := requiredCards(restrain); ok && (!found || len(ids) < len(best)) {
+			best = ids
+			found = true
+		}
+	}
+	return best, found
+}
+
+// requiredCards returns the cards of which the deck must hold at least one for the restrain to pass.
+// It fails for restrains which may pass on a deck without any card, such as "<= 2", and for restrain types it doesn't know.
+func requiredCards(restrain Restrain) ([]int, bool) {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		if restrain.Condition.Judge(0) {
+			return nil, false
+		}
+		return []int{restrain.Id}, true
+	case SetRestrain:
+		if restrain.Condition.Judge(0) {
+			return nil, false
+		}
+		return restrain.Set.Ids, true
+	case PropertyRestrain:
+		return requiredCards(restrain.setRestrain())
+	case RestrainGroup:
+		if restrain.Condition.Judge(0) {
+			return nil, false
+		}
+		ids := make([]int, 0)
+		for _, child := range restrain.Restrains {
+			childIds, ok := requiredCards(child)
+			if !ok {
+				return nil, false
+			}
+			ids = append(ids, childIds...)
+		}
+		return ids, true
+	}
+	return nil, false
+}
@@ -0,0 +1,212 @@
+package ygopro_deck_identifier
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The git repository of an identifier stays with the identifier, its definitions are what moves.
+const PROMOTE_KEPT_ENTRY = ".git"
+
+// GetPreviousPath is where the definitions replaced by the last promote or rollback are kept.
+func (identifier *IdentifierWrapper) GetPreviousPath() string {
+	return path.Join(Config.DeckDefPath, "."+identifier.Name+".previous")
+}
+
+func (identifier *IdentifierWrapper) getStagingPath() string {
+	return path.Join(Config.DeckDefPath, "."+identifier.Name+".staging")
+}
+
+// Promote replaces the definitions of this identifier with a copy of the source's definition directory and
+// publishes them. The copy is compiled once, in the staging directory: with errors nothing changes and the
+// diagnostics point at the source's files, otherwise the compiled identifier is published after the swap, pointing
+// at the files where they are now. The replaced definitions are kept for Rollback.
+func (identifier *IdentifierWrapper) Promote(source *IdentifierWrapper) (bool, Diagnostics) {
+	identifier.resetLock <- 1
+	defer func() { <-identifier.resetLock }()
+
+	if source == identifier {
+		return false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "promote-self", nil, nil, "Can't promote identifier "+identifier.Name+" onto itself.")}
+	}
+	if !source.CheckPathExist() || !identifier.CheckPathExist() {
+		return false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "path-missing", nil, nil, "Identifier path "+source.GetPath()+" or "+identifier.GetPath()+" doesn't exist.")}
+	}
+	start := time.Now()
+	staging := identifier.getStagingPath()
+	os.RemoveAll(staging)
+	if err := copyDefinitions(source.GetPath(), staging); err != nil {
+		os.RemoveAll(staging)
+		return false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "promote-failed", nil, nil, "Failed to copy the definitions of "+source.Name+": "+err.Error())}
+	}
+	next := NewIdentifier(identifier.Name)
+	next.clear()
+	next.RegisterFolder(staging)
+	next.Ready(nil)
+	if next.Diagnostics.HasError() {
+		os.RemoveAll(staging)
+		next.relocate(staging, source.GetPath())
+		return false, next.Diagnostics
+	}
+	previous := identifier.GetPreviousPath()
+	os.RemoveAll(previous)
+	if err := swapDefinitions(identifier.GetPath(), staging, previous); err != nil {
+		os.RemoveAll(staging)
+		return false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "promote-failed", nil, nil, "Failed to replace the definitions of "+identifier.Name+": "+err.Error())}
+	}
+	next.relocate(staging, identifier.GetPath())
+	identifier.publish(next)
+	Metrics.recordReload(next, true, next.Diagnostics, time.Since(start))
+	Logger.Noticef("Promoted the definitions of %v to %v.", source.Name, identifier.Name)
+	return true, next.Diagnostics
+}
+
+// Rollback brings back the definitions replaced by the last promote and reloads. The definitions it replaces are
+// kept in turn, so a second rollback redoes the promote.
+func (identifier *IdentifierWrapper) Rollback() (bool, Diagnostics) {
+	identifier.resetLock <- 1
+	defer func() { <-identifier.resetLock }()
+
+	previous := identifier.GetPreviousPath()
+	if info, err := os.Stat(previous); err != nil || !info.IsDir() {
+		return false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "rollback-missing", nil, nil, "Identifier "+identifier.Name+" has no previous definitions to roll back to.")}
+	}
+	swapped := identifier.getStagingPath()
+	os.RemoveAll(swapped)
+	if err := swapDefinitions(identifier.GetPath(), previous, swapped); err != nil {
+		return false, Diagnostics{newDiagnostic(DIAGNOSTIC_ERROR, "rollback-failed", nil, nil, "Failed to restore the definitions of "+identifier.Name+": "+err.Error())}
+	}
+	if err := os.Rename(swapped, previous); err != nil {
+		Logger.Errorf("Failed to keep the rolled back definitions of %v: %v", identifier.Name, err)
+	}
+	Logger.Noticef("Rolled back the definitions of %v.", identifier.Name)
+	return identifier.reload()
+}
+
+// relocate points the identifier, compiled from the directory at from, to the same files under the directory at
+// to: the origins of its nodes, the files and templates it registered, and its diagnostics.
+func (identifier *Identifier) relocate(from string, to string) {
+	move := relocation(from, to)
+	origins := make(map[*originMessage]bool)
+	var relocateOrigin func(origin *originMessage)
+	relocateOrigin = func(origin *originMessage) {
+		for ; origin != nil && !origins[origin]; origin = origin.Importer {
+			origins[origin] = true
+			origin.File = move(origin.File)
+			relocateOrigin(origin.ExpandedFrom)
+		}
+	}
+	var relocateNode func(node *astNode)
+	relocateNode = func(node *astNode) {
+		relocateOrigin(node.Origin)
+		for _, child := range node.Children {
+			relocateNode(child)
+		}
+	}
+	for _, nodes := range [][]*astNode{identifier.prototype.decks, identifier.prototype.tags, identifier.prototype.sets} {
+		for _, node := range nodes {
+			relocateNode(node)
+		}
+	}
+	files := make(map[string]bool)
+	for file := range identifier.files {
+		files[move(file)] = true
+	}
+	identifier.files = files
+	templates := make(map[string]map[string]*astNode)
+	for file, visible := range identifier.templates {
+		for _, template := range visible {
+			relocateNode(template)
+		}
+		templates[move(file)] = visible
+	}
+	identifier.templates = templates
+	for _, diagnostics := range []Diagnostics{identifier.Diagnostics, identifier.prototype.diagnostics} {
+		for index := range diagnostics {
+			diagnostic := &diagnostics[index]
+			diagnostic.File = move(diagnostic.File)
+			diagnostic.Message = strings.Replace(diagnostic.Message, from, to, -1)
+			relocateOrigin(diagnostic.Importer)
+			relocateOrigin(diagnostic.ExpandedFrom)
+		}
+	}
+}
+
+// relocation moves a path under from to the same place under to, the paths being either as given or absolute.
+func relocation(from string, to string) func(file string) string {
+	absoluteFrom, errFrom := filepath.Abs(from)
+	absoluteTo, errTo := filepath.Abs(to)
+	return func(file string) string {
+		if relative, ok := pathUnder(file, from); ok {
+			return filepath.Join(to, relative)
+		}
+		if errFrom == nil && errTo == nil {
+			if relative, ok := pathUnder(file, absoluteFrom); ok {
+				return filepath.Join(absoluteTo, relative)
+			}
+		}
+		return file
+	}
+}
+
+func pathUnder(file string, directory string) (string, bool) {
+	directory = filepath.Clean(directory)
+	file = filepath.Clean(file)
+	if file == directory {
+		return ".", true
+	}
+	if strings.HasPrefix(file, directory+string(filepath.Separator)) {
+		return file[len(directory)+1:], true
+	}
+	return "", false
+}
+
+// swapDefinitions moves the directory at current to kept and the one at next in its place, carrying the kept
+// entries over. A failure puts current back.
+func swapDefinitions(current string, next string, kept string) error {
+	if err := os.Rename(current, kept); err != nil {
+		return err
+	}
+	if err := os.Rename(next, current); err != nil {
+		os.Rename(kept, current)
+		return err
+	}
+	if _, err := os.Stat(path.Join(kept, PROMOTE_KEPT_ENTRY)); err == nil {
+		os.RemoveAll(path.Join(current, PROMOTE_KEPT_ENTRY))
+		if err := os.Rename(path.Join(kept, PROMOTE_KEPT_ENTRY), path.Join(current, PROMOTE_KEPT_ENTRY)); err != nil {
+			Logger.Errorf("Failed to carry %v over to %v: %v", PROMOTE_KEPT_ENTRY, current, err)
+		}
+	}
+	return nil
+}
+
+// copyDefinitions copies the directory tree at source to target, leaving the kept entries out.
+func copyDefinitions(source string, target string) error {
+	return filepath.Walk(source, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, file)
+		if err != nil {
+			return err
+		}
+		if info.Name() == PROMOTE_KEPT_ENTRY && relative != "." {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		destination := filepath.Join(target, relative)
+		if info.IsDir() {
+			return os.MkdirAll(destination, 0777)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(destination, content, info.Mode().Perm())
+	})
+}
@@ -0,0 +1,256 @@
+package ygopro_deck_identifier
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"sort"
+	"time"
+)
+
+// The recorder keeps every recognition answered by the API in a SQLite database, for meta statistics.
+// It is off unless Config.RecordPath names the database file.
+const RECORDER_QUEUE_SIZE = 4096
+const RECORDER_BATCH_SIZE = 256
+
+// The statistics queries read while the recorder writes: in WAL mode they don't block each other, and the busy
+// timeout makes a connection wait for the lock instead of failing with SQLITE_BUSY.
+const recorderOptions = "?_busy_timeout=5000&_journal_mode=WAL"
+
+const recorderSchema = `
+CREATE TABLE IF NOT EXISTS recognitions (
+	id INTEGER PRIMARY KEY,
+	identifier TEXT NOT NULL,
+	generation INTEGER NOT NULL,
+	deck TEXT NOT NULL,
+	source TEXT NOT NULL DEFAULT '',
+	recorded_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS recognition_tags (
+	recognition INTEGER NOT NULL REFERENCES recognitions(id),
+	tag TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS recognitions_time ON recognitions(identifier, recorded_at);
+CREATE INDEX IF NOT EXISTS recognition_tags_recognition ON recognition_tags(recognition);
+`
+
+// Recording is one recognition to keep. Source is whatever the caller says the deck comes from, a tournament or a room.
+type Recording struct {
+	Identifier string
+	Generation uint64
+	Deck       string
+	Tags       []string
+	Source     string
+	Time       time.Time
+}
+
+type Recorder struct {
+	db    *sql.DB
+	queue chan Recording
+}
+
+// GlobalRecorder is nil while recording is off; its methods do nothing then.
+var GlobalRecorder *Recorder
+
+func OpenRecorderAccordingToConfig() {
+	if len(Config.RecordPath) == 0 {
+		return
+	}
+	recorder, err := OpenRecorder(Config.RecordPath)
+	if err != nil {
+		Logger.Errorf("Failed to open the recognition record %v: %v", Config.RecordPath, err)
+		return
+	}
+	GlobalRecorder = recorder
+	Logger.Noticef("Recording recognitions to %v.", Config.RecordPath)
+}
+
+func OpenRecorder(path string) (*Recorder, error) {
+	db, err := sql.Open("sqlite3", path+recorderOptions)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(recorderSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	recorder := &Recorder{db: db, queue: make(chan Recording, RECORDER_QUEUE_SIZE)}
+	go recorder.run()
+	return recorder, nil
+}
+
+// Record queues a recognition answered as json by the given generation of an identifier. Recognition never waits
+// on the disk: when the queue is full the recording is dropped.
+func (recorder *Recorder) Record(identifier string, generation uint64, source string, json map[string]interface{}) {
+	if recorder == nil {
+		return
+	}
+	recording := Recording{Identifier: identifier, Generation: generation, Source: source, Time: time.Now()}
+	recording.Deck, _ = json["deck"].(string)
+	recording.Tags, _ = json["tag"].([]string)
+	select {
+	case recorder.queue <- recording:
+	default:
+		Logger.Warningf("Recognition record queue is full, dropping a recording of %v.", identifier)
+	}
+}
+
+// run writes the queued recordings, as many as are waiting in one transaction.
+func (recorder *Recorder) run() {
+	for recording := range recorder.queue {
+		batch := []Recording{recording}
+		for waiting := true; waiting && len(batch) < RECORDER_BATCH_SIZE; {
+			select {
+			case next := <-recorder.queue:
+				batch = append(batch, next)
+			default:
+				waiting = false
+			}
+		}
+		if err := recorder.write(batch); err != nil {
+			Logger.Errorf("Failed to record %d recognitions: %v", len(batch), err)
+		}
+	}
+}
+
+func (recorder *Recorder) write(batch []Recording) error {
+	transaction, err := recorder.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, recording := range batch {
+		result, err := transaction.Exec("INSERT INTO recognitions (identifier, generation, deck, source, recorded_at) VALUES (?, ?, ?, ?, ?)",
+			recording.Identifier, int64(recording.Generation), recording.Deck, recording.Source, recording.Time.Unix())
+		if err != nil {
+			transaction.Rollback()
+			return err
+		}
+		id, _ := result.LastInsertId()
+		for _, tag := range recording.Tags {
+			if len(tag) == 0 {
+				continue
+			}
+			if _, err := transaction.Exec("INSERT INTO recognition_tags (recognition, tag) VALUES (?, ?)", id, tag); err != nil {
+				transaction.Rollback()
+				return err
+			}
+		}
+	}
+	return transaction.Commit()
+}
+
+// ======================
+// Share
+// ======================
+
+// ShareQuery selects the recordings of an identifier in [From, To), cut into windows of Window, or kept whole
+// when Window is 0. An empty Source takes every source.
+type ShareQuery struct {
+	Identifier string
+	Source     string
+	From       time.Time
+	To         time.Time
+	Window     time.Duration
+}
+
+type ShareEntry struct {
+	Name  string
+	Count int
+	Share float64
+}
+
+// ShareWindow holds the recordings of one window, Total decks of which the entries count a part, most frequent first.
+type ShareWindow struct {
+	From    time.Time
+	To      time.Time
+	Total   int
+	Entries []ShareEntry
+}
+
+// DeckShare tells how often each deck name was recognized in every window.
+func (recorder *Recorder) DeckShare(query ShareQuery) ([]ShareWindow, error) {
+	return recorder.share(query, "recognitions.deck", "COUNT(*)", "recognitions")
+}
+
+// TagShare tells in how many of the decks of every window each tag was given.
+func (recorder *Recorder) TagShare(query ShareQuery) ([]ShareWindow, error) {
+	return recorder.share(query, "recognition_tags.tag", "COUNT(DISTINCT recognitions.id)", "recognitions JOIN recognition_tags ON recognition_tags.recognition = recognitions.id")
+}
+
+// share counts column over the table in every window, against the number of recordings of the window.
+func (recorder *Recorder) share(query ShareQuery, column string, count string, table string) ([]ShareWindow, error) {
+	from, to := query.From.Unix(), query.To.Unix()
+	window := int64(query.Window / time.Second)
+	if window <= 0 || window > to-from {
+		window = to - from
+	}
+	if window <= 0 {
+		window = 1
+	}
+	where := "recognitions.identifier = ? AND recognitions.recorded_at >= ? AND recognitions.recorded_at < ?"
+	arguments := []interface{}{query.Identifier, from, to}
+	if len(query.Source) > 0 {
+		where += " AND recognitions.source = ?"
+		arguments = append(arguments, query.Source)
+	}
+	bucket := "(recognitions.recorded_at - ?) / ?"
+	windows := make(map[int64]*ShareWindow)
+	windowOf := func(index int64) *ShareWindow {
+		if result, ok := windows[index]; ok {
+			return result
+		}
+		start := from + index*window
+		end := start + window
+		if end > to {
+			end = to
+		}
+		windows[index] = &ShareWindow{From: time.Unix(start, 0), To: time.Unix(end, 0), Entries: make([]ShareEntry, 0)}
+		return windows[index]
+	}
+
+	totals, err := recorder.db.Query("SELECT "+bucket+", COUNT(*) FROM recognitions WHERE "+where+" GROUP BY 1", append([]interface{}{from, window}, arguments...)...)
+	if err != nil {
+		return nil, err
+	}
+	for totals.Next() {
+		var index int64
+		var count int
+		if err := totals.Scan(&index, &count); err != nil {
+			totals.Close()
+			return nil, err
+		}
+		windowOf(index).Total = count
+	}
+	totals.Close()
+
+	statement := "SELECT " + bucket + ", " + column + ", " + count + " FROM " + table + " WHERE " + where + " GROUP BY 1, 2"
+	rows, err := recorder.db.Query(statement, append([]interface{}{from, window}, arguments...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index int64
+		var entry ShareEntry
+		if err := rows.Scan(&index, &entry.Name, &entry.Count); err != nil {
+			return nil, err
+		}
+		result := windowOf(index)
+		if result.Total > 0 {
+			entry.Share = float64(entry.Count) / float64(result.Total)
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	answer := make([]ShareWindow, 0, len(windows))
+	for _, result := range windows {
+		sort.SliceStable(result.Entries, func(i, j int) bool {
+			if result.Entries[i].Count != result.Entries[j].Count {
+				return result.Entries[i].Count > result.Entries[j].Count
+			}
+			return result.Entries[i].Name < result.Entries[j].Name
+		})
+		answer = append(answer, *result)
+	}
+	sort.Slice(answer, func(i, j int) bool { return answer[i].From.Before(answer[j].From) })
+	return answer, rows.Err()
+}
@@ -0,0 +1,187 @@
+package ygopro_deck_identifier
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"github.com/itchio/lzma"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+)
+
+const REPLAY_ID_YRP1 = 0x31707279
+const REPLAY_ID_YRP2 = 0x32707279
+
+const REPLAY_FLAG_COMPRESSED = 0x1
+const REPLAY_FLAG_TAG = 0x2
+const REPLAY_FLAG_SINGLE_MODE = 0x8
+
+// The yrp2 header is the yrp header followed by the seed sequence and four more words.
+const REPLAY_HEADER_SIZE = 32
+const REPLAY_EXTENDED_HEADER_SIZE = 80
+
+// Limits keep a forged header from making the decoder allocate without bound.
+const REPLAY_MAX_DATA_SIZE = 16 << 20
+const REPLAY_MAX_DICTIONARY_SIZE = 64 << 20
+const REPLAY_MAX_PACK_SIZE = 1024
+
+// ReplayPlayer is a duelist of a replay with the deck they brought.
+type ReplayPlayer struct {
+	Name string
+	Deck ygopro_data.Deck
+}
+
+// ParseReplay reads the players of a .yrp or .yrp2 replay. Decks come back as written, main and extra
+// deck already apart; replays of single mode puzzles carry no deck and are refused.
+func ParseReplay(data []byte) ([]ReplayPlayer, error) {
+	if len(data) < REPLAY_HEADER_SIZE {
+		return nil, errors.New("replay is too short to hold a header")
+	}
+	id := binary.LittleEndian.Uint32(data[0:4])
+	flag := binary.LittleEndian.Uint32(data[8:12])
+	dataSize := binary.LittleEndian.Uint32(data[16:20])
+	props := data[24:29]
+	headerSize := REPLAY_HEADER_SIZE
+	switch id {
+	case REPLAY_ID_YRP1:
+	case REPLAY_ID_YRP2:
+		headerSize = REPLAY_EXTENDED_HEADER_SIZE
+	default:
+		return nil, fmt.Errorf("unknown replay id 0x%08x", id)
+	}
+	if len(data) < headerSize {
+		return nil, errors.New("replay is too short to hold a header")
+	}
+	if flag&REPLAY_FLAG_SINGLE_MODE != 0 {
+		return nil, errors.New("single mode replays carry no deck")
+	}
+	content := data[headerSize:]
+	if flag&REPLAY_FLAG_COMPRESSED != 0 {
+		var err error
+		if content, err = decompressReplay(content, props, dataSize); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := &replayReader{content: content}
+	count := 2
+	if flag&REPLAY_FLAG_TAG != 0 {
+		count = 4
+	}
+	players := make([]ReplayPlayer, count)
+	for i := range players {
+		players[i].Name = reader.name()
+	}
+	// start lp, start hand, draw count, duel options
+	reader.skip(16)
+	// Tag duels write the decks of the second team in reverse, so the player named last duels first.
+	order := []int{0, 1}
+	if count == 4 {
+		order = []int{0, 1, 3, 2}
+	}
+	for _, player := range order {
+		players[player].Deck.Main = reader.pack()
+		players[player].Deck.Ex = reader.pack()
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	return players, nil
+}
+
+// LoadReplayDecks parses a replay and prepares the deck of every player for recognition.
+func LoadReplayDecks(data []byte) ([]ReplayPlayer, error) {
+	players, err := ParseReplay(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range players {
+		players[i].Deck = PrepareDeck(players[i].Deck, false)
+	}
+	return players, nil
+}
+
+// decompressReplay inflates the body of a replay. The header keeps the lzma properties and the size,
+// which together make the 13 bytes header lzma streams usually start with.
+func decompressReplay(content []byte, props []byte, dataSize uint32) ([]byte, error) {
+	if dataSize > REPLAY_MAX_DATA_SIZE {
+		return nil, fmt.Errorf("replay claims %d bytes of data, more than %d", dataSize, REPLAY_MAX_DATA_SIZE)
+	}
+	if binary.LittleEndian.Uint32(props[1:5]) > REPLAY_MAX_DICTIONARY_SIZE {
+		return nil, errors.New("replay asks for a too large lzma dictionary")
+	}
+	header := make([]byte, 13)
+	copy(header, props)
+	binary.LittleEndian.PutUint64(header[5:], uint64(dataSize))
+	reader := lzma.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(content)))
+	defer reader.Close()
+	answer, err := ioutil.ReadAll(io.LimitReader(reader, int64(dataSize)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress replay: %v", err)
+	}
+	if len(answer) < int(dataSize) {
+		return nil, errors.New("replay data is truncated")
+	}
+	return answer, nil
+}
+
+// replayReader walks the replay body, remembering the first read past its end instead of panicking.
+type replayReader struct {
+	content  []byte
+	position int
+	err      error
+}
+
+func (reader *replayReader) take(length int) []byte {
+	if reader.err != nil {
+		return nil
+	}
+	if length < 0 || reader.position+length > len(reader.content) {
+		reader.err = errors.New("replay data ends too early")
+		return nil
+	}
+	part := reader.content[reader.position : reader.position+length]
+	reader.position += length
+	return part
+}
+
+func (reader *replayReader) skip(length int) {
+	reader.take(length)
+}
+
+func (reader *replayReader) integer() int {
+	if part := reader.take(4); part != nil {
+		return int(binary.LittleEndian.Uint32(part))
+	}
+	return 0
+}
+
+// name reads a fixed 20 characters UTF-16 name, which ends at its first zero.
+func (reader *replayReader) name() string {
+	part := reader.take(40)
+	characters := make([]uint16, 0, 20)
+	for i := 0; i+1 < len(part); i += 2 {
+		character := binary.LittleEndian.Uint16(part[i:])
+		if character == 0 {
+			break
+		}
+		characters = append(characters, character)
+	}
+	return string(utf16.Decode(characters))
+}
+
+func (reader *replayReader) pack() []int {
+	length := reader.integer()
+	if length > REPLAY_MAX_PACK_SIZE {
+		reader.err = fmt.Errorf("replay deck claims %d cards", length)
+		return nil
+	}
+	pack := make([]int, 0, length)
+	for i := 0; i < length && reader.err == nil; i++ {
+		pack = append(pack, reader.integer())
+	}
+	return pack
+}
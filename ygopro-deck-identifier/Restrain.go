@@ -91,6 +91,82 @@ func (restrain SetRestrain) Judge(deck *ygopro_data.Deck) bool {
 	return restrain.Condition.Judge(count)
 }
 
+// ======================
+// Restrain On Card Properties
+// ======================
+// PropertyRestrain counts the cards matching a filter. The matching cards are looked up once when
+// the definitions are prepared and kept as a set, so it judges like a SetRestrain.
+type PropertyRestrain struct {
+	Filter    CardFilter
+	Set       ygopro_data.Set
+	Range     string
+	Condition Condition
+}
+
+func (PropertyRestrain) Type() string {
+	return "Property"
+}
+
+func (restrain PropertyRestrain) Judge(deck *ygopro_data.Deck) bool {
+	return restrain.setRestrain().Judge(deck)
+}
+
+func (restrain PropertyRestrain) setRestrain() SetRestrain {
+	return SetRestrain{restrain.Set, restrain.Range, restrain.Condition}
+}
+
+// ======================
+// Restrain On Range Sizes
+// ======================
+// SizeRestrain counts the cards of a range, or the different cards when Distinct, whatever they are.
+type SizeRestrain struct {
+	Range     string
+	Distinct  bool
+	Condition Condition
+}
+
+func (restrain SizeRestrain) Type() string {
+	if restrain.Distinct {
+		return "Distinct"
+	}
+	return "Size"
+}
+
+func (restrain SizeRestrain) Judge(deck *ygopro_data.Deck) bool {
+	return restrain.Condition.Judge(restrain.count(deck))
+}
+
+func (restrain SizeRestrain) count(deck *ygopro_data.Deck) int {
+	target := GetDeckTargetClassifiedRange(deck, restrain.Range)
+	if restrain.Distinct {
+		return len(*target)
+	}
+	count := 0
+	for _, value := range *target {
+		count += value
+	}
+	return count
+}
+
+// ======================
+// Restrain Between Expressions
+// ======================
+// CompareRestrain compares two expressions over the deck. The condition is judged on the left value minus
+// the right one against 0, so "left >= right" holds when the difference is ">= 0".
+type CompareRestrain struct {
+	Left      Expression
+	Right     Expression
+	Condition Condition
+}
+
+func (CompareRestrain) Type() string {
+	return "Compare"
+}
+
+func (restrain CompareRestrain) Judge(deck *ygopro_data.Deck) bool {
+	return restrain.Condition.Judge(restrain.Left.Evaluate(deck) - restrain.Right.Evaluate(deck))
+}
+
 // ======================
 // Combined Restrains
 // ======================
@@ -1,35 +1,99 @@
 package ygopro_deck_identifier
 
 import (
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	ygopro_data "github.com/iamipanda/ygopro-data"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
 )
 
 func StartServer() {
+	OpenRecorderAccordingToConfig()
 	router := gin.New()
 	router.Use(gin.Recovery())
 	if gin.IsDebugging() {
 		router.Use(gin.Logger())
 	}
+	router.Use(measureRequest())
+
+	// Prometheus 指标：请求耗时、重读耗时与诊断数、各卡组与标签的识别次数。
+	router.GET("/metrics", func(context *gin.Context) {
+		context.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		context.Status(200)
+		Metrics.Expose(context.Writer)
+	})
 
 	// pull the database and reset the world.
 	router.PATCH("/reload", accessCheck(), func(context *gin.Context) {
 		Logger.Info("Reloading database.")
 		ygopro_data.LoadAllEnvironmentCards()
-		_, text := ReloadAllIdentifier()
-		context.String(200, text)
+		_, reports := ReloadAllIdentifier()
+		json := make(map[string]interface{})
+		for name, diagnostics := range reports {
+			json[name] = GlobalIdentifierMap[name].Current().CompileReportJson(true, diagnostics)
+		}
+		context.JSON(200, json)
 	})
 
 	router.Use(identifierCheck())
 	router.POST("/:identifierName", extractDeck(), func(context *gin.Context) {
-		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper).Current()
 		deck := context.MustGet("Deck").(ygopro_data.Deck)
-		context.JSON(200, identifier.RecognizeAsJson(deck))
+		json := identifier.RecognizeAsJson(deck)
+		GlobalRecorder.Record(identifier.Name, identifier.Generation, recordSource(context), json)
+		context.JSON(200, json)
 	})
+	// candidates=N 附带前 N 个候选卡组及分数，candidates=all 返回全部候选。
 	router.POST("/:identifierName/recognize", extractDeck(), func(context *gin.Context) {
-		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper).Current()
 		deck := context.MustGet("Deck").(ygopro_data.Deck)
-		context.JSON(200, identifier.RecognizeAsJson(deck))
+		json := identifier.RecognizeAsJson(deck)
+		GlobalRecorder.Record(identifier.Name, identifier.Generation, recordSource(context), json)
+		if candidates := context.Query("candidates"); candidates == "all" {
+			json["candidates"] = CandidatesToJson(identifier.RecognizeCandidates(deck, 0))
+		} else if limit, err := strconv.Atoi(candidates); err == nil && limit > 0 {
+			json["candidates"] = CandidatesToJson(identifier.RecognizeCandidates(deck, limit))
+		}
+		context.JSON(200, json)
+	})
+	// 识别录像中每位玩家的卡组，录像以 multipart 的 replay 字段上传。
+	router.POST("/:identifierName/replay", extractReplay(), func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper).Current()
+		players := context.MustGet("Players").([]ReplayPlayer)
+		json := make([]interface{}, 0)
+		for index, player := range players {
+			result := identifier.RecognizeAsJson(player.Deck)
+			GlobalRecorder.Record(identifier.Name, identifier.Generation, recordSource(context), result)
+			result["player"] = player.Name
+			result["index"] = index
+			json = append(json, result)
+		}
+		context.JSON(200, json)
+	})
+	// 批量识别：请求体为卡组数组，结果以 NDJSON 逐行返回。
+	router.POST("/:identifierName/batch", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		separate := context.DefaultQuery("separate", "false") == "true"
+		decks := make([]BatchDeck, 0)
+		if err := json.NewDecoder(context.Request.Body).Decode(&decks); err != nil {
+			context.AbortWithStatusJSON(400, "Can't read the deck list: "+err.Error())
+			return
+		}
+		context.Header("Content-Type", "application/x-ndjson")
+		context.Status(200)
+		encoder := json.NewEncoder(context.Writer)
+		source := recordSource(context)
+		identifier.RecognizeBatch(decks, separate, func(result BatchResult) {
+			answer := result.ToJson()
+			if len(result.Error) == 0 {
+				GlobalRecorder.Record(identifier.Name, result.Generation, source, answer)
+			}
+			encoder.Encode(answer)
+			context.Writer.Flush()
+		})
 	})
 
 	// 以下的操作，全部需要 Access Key 操作。
@@ -38,21 +102,157 @@ func StartServer() {
 	// 重读数据
 	router.POST("/:identifierName/reload", func(context *gin.Context) {
 		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
-		_, text := identifier.Reload()
-		context.String(200, text)
+		ok, diagnostics := identifier.Reload()
+		context.JSON(200, identifier.Current().CompileReportJson(ok, diagnostics))
+	})
+	// 发布定义：将 from 识别器的整个定义目录复制到本识别器，编译无错误后发布这次编译的结果，并保留旧版本以供回滚。
+	router.POST("/:identifierName/promote", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		source, ok := GlobalIdentifierMap[context.Query("from")]
+		if !ok {
+			context.AbortWithStatusJSON(404, "Can't find Identifier named "+context.Query("from"))
+			return
+		}
+		ok, diagnostics := identifier.Promote(source)
+		context.JSON(200, identifier.Current().CompileReportJson(ok, diagnostics))
+	})
+	// 回滚到上一次发布前的定义，再次回滚即恢复发布。
+	router.POST("/:identifierName/rollback", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		ok, diagnostics := identifier.Rollback()
+		context.JSON(200, identifier.Current().CompileReportJson(ok, diagnostics))
 	})
 	// 预览数据
 	router.POST("/:identifierName/preview", func(context *gin.Context) {
 		bytes, _ := context.GetRawData()
 		content := string(bytes)
 		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
-		_, log := identifier.GetCompilePreview(content, "compile")
-		context.String(200, log)
+		target, diagnostics := identifier.GetCompilePreview(content, "compile")
+		context.JSON(200, target.Current().CompileReportJson(true, diagnostics))
+	})
+	// 格式化定义
+	router.POST("/:identifierName/format", func(context *gin.Context) {
+		bytes, _ := context.GetRawData()
+		if formatted, diagnostics := FormatDefinition(string(bytes), "anonymous"); len(diagnostics) == 0 {
+			context.String(200, formatted)
+		} else {
+			context.JSON(422, diagnostics.ToJson())
+		}
+	})
+	router.GET("/:identifierName/format/check", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		files, diagnostics := identifier.CheckFormat()
+		json := make(map[string]interface{})
+		json["files"] = files
+		json["diagnostics"] = diagnostics.ToJson()
+		context.JSON(200, json)
+	})
+	// 静态检查定义：被遮蔽的卡组、同优先级冲突、重复定义、未使用的系列与未挂载的标签。
+	router.GET("/:identifierName/lint", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper).Current()
+		json := make(map[string]interface{})
+		json["identifier"] = identifier.Name
+		json["generation"] = identifier.Generation
+		json["diagnostics"] = identifier.Lint().ToJson()
+		context.JSON(200, json)
+	})
+	// 回归测试：识别 tests 目录下标注了预期的卡组，失败的附带详细识别过程。failed=true 时只返回失败的卡组。
+	router.GET("/:identifierName/test", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		report := identifier.RunTests()
+		json := report.ToJson()
+		if context.Query("failed") == "true" {
+			failed := make([]interface{}, 0)
+			for index := range report.Outcomes {
+				if !report.Outcomes[index].Passed() {
+					failed = append(failed, report.Outcomes[index].ToJson())
+				}
+			}
+			json["outcomes"] = failed
+		}
+		context.JSON(200, json)
+	})
+	// 阈值调优：用回归测试卡组中标注为该卡组的作为正例、标注为其他卡组的作为反例，统计每个计数约束的取值分布，
+	// 给出区分正反例最好的阈值，并报告当前与建议阈值的精确率与召回率。
+	router.GET("/:identifierName/tune/:deckName", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
+		deckName := context.Param("deckName")
+		if report, ok := identifier.TuneDeck(deckName); ok {
+			context.JSON(200, report.ToJson())
+		} else {
+			context.AbortWithStatusJSON(404, "Can't find deck named "+deckName)
+		}
+	})
+	// 对比识别结果：用两个识别器（或一个识别器与预览定义）识别同一批卡组，按新旧卡组名的变化汇总。
+	// 请求体为 {"new": 识别器名, "definition": 预览定义, "decks": 卡组数组}，不给卡组时使用本识别器的回归测试卡组。
+	router.POST("/:identifierName/diff", func(context *gin.Context) {
+		request := DiffRequest{}
+		if err := json.NewDecoder(context.Request.Body).Decode(&request); err != nil && err != io.EOF {
+			context.AbortWithStatusJSON(400, "Can't read the diff request: "+err.Error())
+			return
+		}
+		request.Old = context.MustGet("Identifier").(*IdentifierWrapper).Name
+		report, diagnostics, ok := request.Diff()
+		if !ok {
+			context.AbortWithStatusJSON(404, "Can't find Identifier named "+request.New)
+			return
+		}
+		answer := report.ToJson()
+		answer["diagnostics"] = diagnostics.ToJson()
+		context.JSON(200, answer)
+	})
+	// 覆盖率：用一批卡组（不给时为回归测试卡组）识别，按定义文件与行统计每个卡组、标签与约束被判断与通过的次数，
+	// 并指出从未匹配的卡组与标签，以及从不影响结果、可以删去的约束。请求体与对比接口相同，只读取 decks 与 separate。
+	router.POST("/:identifierName/coverage", func(context *gin.Context) {
+		request := CoverageRequest{}
+		if err := json.NewDecoder(context.Request.Body).Decode(&request); err != nil && err != io.EOF {
+			context.AbortWithStatusJSON(400, "Can't read the coverage request: "+err.Error())
+			return
+		}
+		report, errors := request.Coverage(context.MustGet("Identifier").(*IdentifierWrapper))
+		answer := report.ToJson()
+		answer["errors"] = errors
+		context.JSON(200, answer)
+	})
+	// 环境统计：按时间窗口与来源统计卡组与标签占比。from、to 为 RFC 3339 时间或 Unix 秒，默认最近 7 天；
+	// window 为 Go 时长（如 24h），不给时整个区间为一个窗口；source 不给时统计所有来源。
+	shareApi := router.Group("/:identifierName/share")
+	{
+		shareApi.GET("/decks", func(context *gin.Context) {
+			answerShare(context, GlobalRecorder.DeckShare)
+		})
+		shareApi.GET("/tags", func(context *gin.Context) {
+			answerShare(context, GlobalRecorder.TagShare)
+		})
+	}
+	// 未知卡组聚类：请求体为卡组数组，识别为未知的卡组按主卡组的相似度聚类，并给出可用预览接口检查的定义草稿。
+	// threshold 为加入聚类所需的最低 Jaccard 相似度，min 为聚类的最少卡组数。
+	router.POST("/:identifierName/cluster", func(context *gin.Context) {
+		identifier := context.MustGet("Identifier").(*IdentifierWrapper).Current()
+		decks := make([]BatchDeck, 0)
+		if err := json.NewDecoder(context.Request.Body).Decode(&decks); err != nil {
+			context.AbortWithStatusJSON(400, "Can't read the deck list: "+err.Error())
+			return
+		}
+		threshold, err := strconv.ParseFloat(context.DefaultQuery("threshold", strconv.FormatFloat(CLUSTER_DEFAULT_THRESHOLD, 'f', -1, 64)), 64)
+		if err != nil {
+			context.AbortWithStatusJSON(400, "Can't read threshold: "+err.Error())
+			return
+		}
+		minimum, err := strconv.Atoi(context.DefaultQuery("min", strconv.Itoa(CLUSTER_DEFAULT_MINIMUM)))
+		if err != nil {
+			context.AbortWithStatusJSON(400, "Can't read min: "+err.Error())
+			return
+		}
+		corpus, errors := LoadCorpus(decks, context.DefaultQuery("separate", "false") == "true")
+		answer := identifier.ClusterUnknownDecks(corpus, threshold, minimum).ToJson()
+		answer["errors"] = errors
+		context.JSON(200, answer)
 	})
 	router.POST("/:identifierName/verbose", extractDeck(), func(context *gin.Context) {
 		identifier := context.MustGet("Identifier").(*IdentifierWrapper)
 		deck := context.MustGet("Deck").(ygopro_data.Deck)
-		context.JSON(200, identifier.VerboseRecognizeAsJson(deck))
+		context.JSON(200, identifier.Current().VerboseRecognizeAsJson(deck))
 	})
 
 	// 对运行中的结构，进行读取。
@@ -156,6 +356,72 @@ func StartServer() {
 	router.Run(Config.Listening)
 }
 
+// recordSource is where the caller says the deck comes from, a tournament or a room, given as the source parameter.
+func recordSource(c *gin.Context) string {
+	if source := c.Query("source"); len(source) > 0 {
+		return source
+	}
+	return c.PostForm("source")
+}
+
+func answerShare(c *gin.Context, share func(ShareQuery) ([]ShareWindow, error)) {
+	if GlobalRecorder == nil {
+		c.AbortWithStatusJSON(404, "Recording is off, set RecordPath in the config to turn it on.")
+		return
+	}
+	query := ShareQuery{Identifier: c.MustGet("Identifier").(*IdentifierWrapper).Name, Source: c.Query("source"), To: time.Now()}
+	var err error
+	if to := c.Query("to"); len(to) > 0 {
+		if query.To, err = parseShareTime(to); err != nil {
+			c.AbortWithStatusJSON(400, "Can't read to: "+err.Error())
+			return
+		}
+	}
+	query.From = query.To.Add(-7 * 24 * time.Hour)
+	if from := c.Query("from"); len(from) > 0 {
+		if query.From, err = parseShareTime(from); err != nil {
+			c.AbortWithStatusJSON(400, "Can't read from: "+err.Error())
+			return
+		}
+	}
+	if window := c.Query("window"); len(window) > 0 {
+		if query.Window, err = time.ParseDuration(window); err != nil {
+			c.AbortWithStatusJSON(400, "Can't read window: "+err.Error())
+			return
+		}
+	}
+	windows, err := share(query)
+	if err != nil {
+		c.AbortWithStatusJSON(500, "Failed to query the record: "+err.Error())
+		return
+	}
+	c.JSON(200, ShareWindowsToJson(query, windows))
+}
+
+func parseShareTime(text string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, text)
+}
+
+// measureRequest times every request by route. Only known identifiers are labeled, so made up names can't grow the series.
+func measureRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		identifier := c.Param("identifierName")
+		if _, ok := GlobalIdentifierMap[identifier]; !ok {
+			identifier = ""
+		}
+		Metrics.Observe(METRIC_REQUEST_DURATION, time.Since(start).Seconds(), "method", c.Request.Method, "route", route, "identifier", identifier)
+	}
+}
+
 func identifierCheck() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		identifierName := c.Param("identifierName")
@@ -211,12 +477,58 @@ func extractDeck() gin.HandlerFunc {
 	}
 }
 
+// setDeck reads the deck in whatever format DecodeDeck recognizes.
 func setDeck(c *gin.Context, deckString string, separate bool) {
-	deck := ygopro_data.LoadYdkFromString(deckString)
+	deck, _, err := DecodeDeck(deckString)
+	if err != nil {
+		c.AbortWithStatusJSON(400, "Can't read the deck: "+err.Error())
+		return
+	}
+	c.Set("Deck", PrepareDeck(deck, separate || gin.Mode() == gin.DebugMode))
+}
+
+func extractReplay() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header, err := c.FormFile("replay")
+		if err != nil {
+			c.AbortWithStatusJSON(400, "Can't find the replay file: "+err.Error())
+			return
+		}
+		if header.Size > REPLAY_MAX_DATA_SIZE {
+			c.AbortWithStatusJSON(413, "Replay file is too large.")
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.AbortWithStatusJSON(400, "Can't read the replay file: "+err.Error())
+			return
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			c.AbortWithStatusJSON(400, "Can't read the replay file: "+err.Error())
+			return
+		}
+		setReplay(c, data)
+	}
+}
+
+func setReplay(c *gin.Context, data []byte) {
+	players, err := LoadReplayDecks(data)
+	if err != nil {
+		c.AbortWithStatusJSON(422, "Can't parse the replay: "+err.Error())
+		return
+	}
+	c.Set("Players", players)
+}
+
+// PrepareDeck summarizes and classifies a loaded deck, moving extra deck monsters out of main if asked.
+// It only reads the card cache, so it is safe to call from several goroutines.
+func PrepareDeck(deck ygopro_data.Deck, separate bool) ygopro_data.Deck {
 	deck.Summary()
-	if separate || gin.Mode() == gin.DebugMode {
-		deck.SeparateExFromMain(ygopro_data.GetEnvironment("zh-CN"))
+	if separate {
+		deck.SeparateExFromMainFromCache(ygopro_data.GetEnvironment("zh-CN"))
 	}
 	deck.Classify()
-	c.Set("Deck", deck)
+	return deck
 }
@@ -0,0 +1,206 @@
+package ygopro_deck_identifier
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Templates are written as
+//
+//	template: archetype($X, $N)
+//	  deck: $X [$N]
+//	    set: $X main >= 6
+//
+//	use: archetype(影依, 5)
+//
+// The body is kept as text and compiled again for every use with the parameters replaced, so the use
+// turns into ordinary deck, tag and set nodes. A file sees its own templates, wherever they are written, and
+// those of the files it imports, so a library can define a block once for many archetypes. The file's own
+// templates come first.
+var templateSignatureReg, _ = regexp.Compile(`^([^()\s]+)\s*\((.*)\)$`)
+
+const TEMPLATE_PARAMETER_PREFIX = "$"
+
+// parseTemplateSignature splits "name(a, b)" into the name and its trimmed arguments.
+func parseTemplateSignature(text string) (string, []string, bool) {
+	matches := templateSignatureReg.FindStringSubmatch(strings.TrimSpace(text))
+	if matches == nil {
+		return "", nil, false
+	}
+	arguments := make([]string, 0)
+	if len(strings.TrimSpace(matches[2])) > 0 {
+		for _, argument := range strings.Split(matches[2], ",") {
+			arguments = append(arguments, strings.TrimSpace(argument))
+		}
+	}
+	return matches[1], arguments, true
+}
+
+func (compiler *Compiler) generateTemplateNode(line string, message *originMessage) *astNode {
+	if compiler.current != compiler.Root {
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "bad-template", nil, message, "Templates can only be defined at the top level.")
+		return nil
+	}
+	name, parameters, ok := parseTemplateSignature(line)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, parameter := range parameters {
+		if !strings.HasPrefix(parameter, TEMPLATE_PARAMETER_PREFIX) || len(parameter) == len(TEMPLATE_PARAMETER_PREFIX) || seen[parameter] {
+			compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "bad-template", nil, message, "Template parameter %v must be unique and start with %v", parameter, TEMPLATE_PARAMETER_PREFIX)
+			return nil
+		}
+		seen[parameter] = true
+	}
+	if _, ok := compiler.templates[name]; ok {
+		compiler.Diagnostics.report(DIAGNOSTIC_WARNING, "template-redefined", nil, message, "Rewriting existing template %v.", name)
+	}
+	return newAstNode("template", strings.TrimSpace(line))
+}
+
+// recordTemplateLine keeps the lines indented under a template as its body, untouched but for the indentation
+// of the template itself. It tells whether the line was taken.
+func (compiler *Compiler) recordTemplateLine(line string, message *originMessage) bool {
+	if compiler.recording == nil {
+		return false
+	}
+	line = strings.Replace(line, "\t", tabSpaceString, -1)
+	if len(strings.TrimSpace(line)) == 0 {
+		return true
+	}
+	if compiler.measureLineStrip(line) <= compiler.recordingTab {
+		compiler.finishTemplate()
+		return false
+	}
+	indentation := compiler.recordingTab - 1 + COMPILER_TAB_SPACE_LENGTH
+	if spaces := len(line) - len(strings.TrimLeft(line, " ")); spaces < indentation {
+		indentation = spaces
+	}
+	node := newAstNode("template line", strings.TrimRight(line[indentation:], " "))
+	node.Origin = message
+	compiler.recording.Children = append(compiler.recording.Children, node)
+	return true
+}
+
+func (compiler *Compiler) finishTemplate() {
+	template := compiler.recording
+	if template == nil {
+		return
+	}
+	compiler.recording = nil
+	name, _, _ := parseTemplateSignature(template.Value)
+	if len(template.Children) == 0 {
+		compiler.Diagnostics.report(DIAGNOSTIC_WARNING, "empty-template", template, nil, "Template %v has no line.", name)
+	}
+	compiler.templates[name] = template
+}
+
+// useTemplate expands the use right away when the file defined the template above it. Other uses wait for
+// ExpandImportedTemplates, once the imports of the file are known.
+func (compiler *Compiler) useTemplate(use *astNode) {
+	name, _, ok := parseTemplateSignature(use.Value)
+	if _, defined := compiler.templates[name]; ok && !defined && compiler.imported == nil && compiler.current == compiler.Root {
+		compiler.pending = append(compiler.pending, use)
+		return
+	}
+	compiler.expandTemplate(use)
+}
+
+// ExpandImportedTemplates expands the pending uses where they stand, with the templates of the file and then the
+// imported ones. The uses nothing defines are reported here.
+func (compiler *Compiler) ExpandImportedTemplates(imported map[string]*astNode) {
+	compiler.imported = imported
+	if compiler.imported == nil {
+		compiler.imported = make(map[string]*astNode)
+	}
+	pending := compiler.pending
+	compiler.pending = nil
+	for _, use := range pending {
+		index := -1
+		for position, node := range compiler.Root.Children {
+			if node == use {
+				index = position
+				break
+			}
+		}
+		if index < 0 {
+			continue
+		}
+		rest := append([]*astNode{}, compiler.Root.Children[index+1:]...)
+		compiler.Root.Children = compiler.Root.Children[:index+1]
+		compiler.Layers = append(make([]*astNode, 0), compiler.Root)
+		compiler.current = compiler.Root
+		compiler.expandTemplate(use)
+		compiler.Root.Children = append(compiler.Root.Children, rest...)
+	}
+}
+
+// VisibleTemplates are the templates a file importing this one may use: the imported ones, and its own over them.
+func (compiler *Compiler) VisibleTemplates() map[string]*astNode {
+	templates := make(map[string]*astNode)
+	for name, template := range compiler.imported {
+		templates[name] = template
+	}
+	for name, template := range compiler.templates {
+		templates[name] = template
+	}
+	return templates
+}
+
+// expandTemplate compiles the body of the used template in place of the use line. The expanded lines keep
+// the template's own origin, pointing back to the use line through ExpandedFrom; they belong to the file of the
+// use line, so they take its importer.
+func (compiler *Compiler) expandTemplate(use *astNode) {
+	if compiler.current != compiler.Root {
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "bad-template-use", use, nil, "Templates can only be used at the top level.")
+		return
+	}
+	name, arguments, ok := parseTemplateSignature(use.Value)
+	if !ok {
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "bad-template-use", use, nil, "Can't read the template use %v", use.Value)
+		return
+	}
+	template, ok := compiler.templates[name]
+	if !ok {
+		template, ok = compiler.imported[name]
+	}
+	if !ok {
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "unknown-template", use, nil, "Can't find template named %v in this file or the files it imports", name)
+		return
+	}
+	_, parameters, _ := parseTemplateSignature(template.Value)
+	if len(parameters) != len(arguments) {
+		compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "bad-template-use", use, nil, "Template %v takes %d arguments, not %d", name, len(parameters), len(arguments))
+		return
+	}
+	for _, expanding := range compiler.expanding {
+		if expanding == name {
+			compiler.Diagnostics.report(DIAGNOSTIC_ERROR, "template-cycle", use, nil, "Template %v uses itself: %v", name, strings.Join(append(compiler.expanding, name), " -> "))
+			return
+		}
+	}
+	// Longer parameters first, so $NAME is not cut by $N.
+	replacements := make([]string, 0)
+	order := make([]int, len(parameters))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return len(parameters[order[i]]) > len(parameters[order[j]]) })
+	for _, i := range order {
+		replacements = append(replacements, parameters[i], arguments[i])
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	compiler.expanding = append(compiler.expanding, name)
+	for _, line := range template.Children {
+		origin := newOriginMessage(line.Origin.Line, line.Origin.Text, line.Origin.File)
+		origin.Importer = use.Origin.Importer
+		origin.ExpandedFrom = use.Origin
+		compiler.compileLine(replacer.Replace(line.Value), origin)
+	}
+	compiler.expanding = compiler.expanding[:len(compiler.expanding)-1]
+	// Comments closing the body belong to the template, not to the line after the use.
+	compiler.comments = nil
+}
@@ -0,0 +1,386 @@
+package ygopro_deck_identifier
+
+import (
+	"fmt"
+	"github.com/iamipanda/ygopro-data"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tuning measures the counting restrains of a deck definition against a labeled corpus. The decks labeled with
+// the deck's name are the positive samples, those labeled with any other deck the negative ones; decks labeled
+// only with tags are left out. Labels are the names recognition answers, so the prefixes and appendixes the tags
+// of the deck may put around its name are taken off first. Every restrain bounding a count from one side gets the bound telling the samples
+// apart best, by Youden's J (recall minus the false positive rate), ties going to the higher F1 and then to the
+// bound closest to the current one.
+
+// TuneScore counts how a condition, or the whole definition, judges the samples.
+type TuneScore struct {
+	TruePositive  int
+	FalsePositive int
+	FalseNegative int
+	TrueNegative  int
+}
+
+func (score TuneScore) Precision() float64 {
+	if score.TruePositive+score.FalsePositive == 0 {
+		return 0
+	}
+	return float64(score.TruePositive) / float64(score.TruePositive+score.FalsePositive)
+}
+
+func (score TuneScore) Recall() float64 {
+	if score.TruePositive+score.FalseNegative == 0 {
+		return 0
+	}
+	return float64(score.TruePositive) / float64(score.TruePositive+score.FalseNegative)
+}
+
+func (score TuneScore) F1() float64 {
+	precision, recall := score.Precision(), score.Recall()
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
+
+// Separation is Youden's J, 1 when the condition takes exactly the positive samples.
+func (score TuneScore) Separation() float64 {
+	falsePositiveRate := 0.0
+	if score.FalsePositive+score.TrueNegative > 0 {
+		falsePositiveRate = float64(score.FalsePositive) / float64(score.FalsePositive+score.TrueNegative)
+	}
+	return score.Recall() - falsePositiveRate
+}
+
+func (score *TuneScore) add(positive bool, passed bool) {
+	switch {
+	case positive && passed:
+		score.TruePositive += 1
+	case positive:
+		score.FalseNegative += 1
+	case passed:
+		score.FalsePositive += 1
+	default:
+		score.TrueNegative += 1
+	}
+}
+
+// TuneValue is how many positive and negative samples count Value.
+type TuneValue struct {
+	Value    int
+	Positive int
+	Negative int
+}
+
+// TuneRestrain is a counting restrain of the deck, found at Path among the restrains and their groups.
+// Suggested equals Current when the restrain isn't bounded from one side.
+type TuneRestrain struct {
+	Path         []int
+	Restrain     Restrain
+	Target       string
+	Distribution []TuneValue
+	Current      Condition
+	CurrentScore TuneScore
+	Suggested    Condition
+	SuggestScore TuneScore
+	Changed      bool
+}
+
+type TuneReport struct {
+	Identifier string
+	Generation uint64
+	Deck       string
+	Positive   int
+	Negative   int
+	Restrains  []TuneRestrain
+	// Current and Suggested judge the samples with the whole definition, before and after every suggestion,
+	// apart from the other decks.
+	Current   TuneScore
+	Suggested TuneScore
+	// Diagnostics are what reading the corpus found.
+	Diagnostics Diagnostics
+}
+
+// TuneDeck tunes the deck named deckName on the labeled decks. It fails when the identifier has no such deck.
+func (identifier *Identifier) TuneDeck(deckName string, tests []DeckTest) (*TuneReport, bool) {
+	var deckType *Deck
+	for index := range identifier.Decks {
+		if identifier.Decks[index].Name == deckName {
+			deckType = &identifier.Decks[index]
+			break
+		}
+	}
+	if deckType == nil {
+		return nil, false
+	}
+	report := &TuneReport{Identifier: identifier.Name, Generation: identifier.Generation, Deck: deckName, Restrains: make([]TuneRestrain, 0)}
+	samples := make([]*DeckTest, 0)
+	positives := make([]bool, 0)
+	for index := range tests {
+		if len(tests[index].ExpectDeck) == 0 {
+			continue
+		}
+		positive := identifier.tuneLabel(tests[index].ExpectDeck, deckType)
+		samples = append(samples, &tests[index])
+		positives = append(positives, positive)
+		if positive {
+			report.Positive += 1
+		} else {
+			report.Negative += 1
+		}
+	}
+
+	identifier.collectTuneRestrains(deckType.Restrains, nil, &report.Restrains)
+	for index := range report.Restrains {
+		report.Restrains[index].tune(samples, positives)
+	}
+
+	suggested := deckType.Classification
+	for _, restrain := range report.Restrains {
+		if restrain.Changed {
+			suggested.Restrains = replaceTuneCondition(suggested.Restrains, restrain.Path, restrain.Suggested)
+		}
+	}
+	for index, sample := range samples {
+		report.Current.add(positives[index], deckType.Judge(sample.Deck))
+		report.Suggested.add(positives[index], suggested.Judge(sample.Deck))
+	}
+	return report, true
+}
+
+// tuneLabel tells whether the label names the deck. A label naming a deck as it is names that deck, otherwise
+// the affixes of the deck's tags and of the global tags are taken off, in any order, until the deck's name is left.
+func (identifier *Identifier) tuneLabel(label string, deckType *Deck) bool {
+	for _, other := range identifier.Decks {
+		if other.Name == label {
+			return other.Name == deckType.Name
+		}
+	}
+	prefixes, appendixes := make([]string, 0), make([]string, 0)
+	for _, tags := range [][]Tag{deckType.CheckTags, deckType.ForceTags, identifier.GlobalTags} {
+		for _, tag := range tags {
+			if len(tag.Name) == 0 {
+				continue
+			}
+			if tag.Is("prefix") {
+				prefixes = append(prefixes, tag.Name)
+			} else if tag.Is("appendix") {
+				appendixes = append(appendixes, tag.Name)
+			}
+		}
+	}
+	return stripTuneAffixes(label, deckType.Name, prefixes, appendixes)
+}
+
+func stripTuneAffixes(label string, name string, prefixes []string, appendixes []string) bool {
+	if label == name {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(label, prefix) && stripTuneAffixes(label[len(prefix):], name, prefixes, appendixes) {
+			return true
+		}
+	}
+	for _, appendix := range appendixes {
+		if strings.HasSuffix(label, appendix) && stripTuneAffixes(label[:len(label)-len(appendix)], name, prefixes, appendixes) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectTuneRestrains lists the restrains counting cards, looking into the groups.
+func (identifier *Identifier) collectTuneRestrains(restrains []Restrain, parent []int, result *[]TuneRestrain) {
+	for index, restrain := range restrains {
+		path := append(append([]int{}, parent...), index)
+		if group, ok := restrain.(RestrainGroup); ok {
+			identifier.collectTuneRestrains(group.Restrains, path, result)
+			continue
+		}
+		if condition, ok := tuneCondition(restrain); ok {
+			*result = append(*result, TuneRestrain{Path: path, Restrain: restrain, Target: identifier.tuneTarget(restrain), Current: condition})
+		}
+	}
+}
+
+// tune counts the restrain on every sample, then scans the bounds between the counts seen.
+func (tuned *TuneRestrain) tune(samples []*DeckTest, positives []bool) {
+	counts := make(map[int]*TuneValue)
+	values := make([]int, len(samples))
+	for index, sample := range samples {
+		values[index] = tuneCount(tuned.Restrain, &sample.Deck)
+		value, ok := counts[values[index]]
+		if !ok {
+			value = &TuneValue{Value: values[index]}
+			counts[values[index]] = value
+		}
+		if positives[index] {
+			value.Positive += 1
+		} else {
+			value.Negative += 1
+		}
+	}
+	tuned.Distribution = make([]TuneValue, 0, len(counts))
+	for _, value := range counts {
+		tuned.Distribution = append(tuned.Distribution, *value)
+	}
+	sort.Slice(tuned.Distribution, func(i, j int) bool { return tuned.Distribution[i].Value < tuned.Distribution[j].Value })
+
+	score := func(condition Condition) TuneScore {
+		result := TuneScore{}
+		for index := range samples {
+			result.add(positives[index], condition.Judge(values[index]))
+		}
+		return result
+	}
+	tuned.CurrentScore = score(tuned.Current)
+	tuned.Suggested, tuned.SuggestScore = tuned.Current, tuned.CurrentScore
+
+	low, high, ok := conditionInterval(tuned.Current)
+	if !ok || (low > 0) == (high != math.MaxInt32) {
+		return
+	}
+	operator, current := ">=", low
+	if high != math.MaxInt32 {
+		operator, current = "<=", high
+	}
+	bounds := []int{current}
+	for _, value := range tuned.Distribution {
+		bounds = append(bounds, value.Value)
+	}
+	best, bestScore, found := 0, TuneScore{}, false
+	for _, bound := range bounds {
+		if operator == ">=" && bound < 1 {
+			continue
+		}
+		candidate := score(NewCondition(operator, bound))
+		if !found || tuneBetter(candidate, bestScore, bound, best, current) {
+			best, bestScore, found = bound, candidate, true
+		}
+	}
+	if !found {
+		return
+	}
+	if bestLow, bestHigh, _ := conditionInterval(NewCondition(operator, best)); bestLow != low || bestHigh != high {
+		tuned.Suggested, tuned.SuggestScore, tuned.Changed = NewCondition(operator, best), bestScore, true
+	}
+}
+
+// tuneBetter tells whether the candidate bound beats the best so far: better separation, then better F1,
+// then closer to the current bound, then lower.
+func tuneBetter(candidate TuneScore, best TuneScore, bound int, bestBound int, current int) bool {
+	if candidate.Separation() != best.Separation() {
+		return candidate.Separation() > best.Separation()
+	}
+	if candidate.F1() != best.F1() {
+		return candidate.F1() > best.F1()
+	}
+	distance, bestDistance := absInt(bound-current), absInt(bestBound-current)
+	if distance != bestDistance {
+		return distance < bestDistance
+	}
+	return bound < bestBound
+}
+
+func tuneCondition(restrain Restrain) (Condition, bool) {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		return restrain.Condition, true
+	case SetRestrain:
+		return restrain.Condition, true
+	case PropertyRestrain:
+		return restrain.Condition, true
+	case SizeRestrain:
+		return restrain.Condition, true
+	}
+	return Condition{}, false
+}
+
+func tuneCount(restrain Restrain, deck *ygopro_data.Deck) int {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		return restrain.verboseJudge(deck).value
+	case SetRestrain:
+		return restrain.verboseJudge(deck).value
+	case PropertyRestrain:
+		return restrain.verboseJudge(deck).value
+	case SizeRestrain:
+		return restrain.count(deck)
+	}
+	return 0
+}
+
+// tuneTarget names what the restrain counts, the way a definition would write it.
+func (identifier *Identifier) tuneTarget(restrain Restrain) string {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		name := strconv.Itoa(restrain.Id)
+		if card, ok := identifier.BindingEnvironment.GetCard(restrain.Id); ok {
+			name = card.Name
+		}
+		return strings.TrimSpace(name + " " + restrain.Range)
+	case SetRestrain:
+		return strings.TrimSpace("[" + restrain.Set.Name + "] " + restrain.Range)
+	case PropertyRestrain:
+		return strings.TrimSpace("{" + restrain.Filter.Text + "} " + restrain.Range)
+	case SizeRestrain:
+		return strings.TrimSpace(strings.ToLower(restrain.Type()) + " " + restrain.Range)
+	}
+	return restrain.Type()
+}
+
+// replaceTuneCondition copies the restrains with the condition of the one at path replaced, leaving the original
+// restrains and groups untouched.
+func replaceTuneCondition(restrains []Restrain, path []int, condition Condition) []Restrain {
+	result := append([]Restrain{}, restrains...)
+	switch restrain := result[path[0]].(type) {
+	case RestrainGroup:
+		restrain.Restrains = replaceTuneCondition(restrain.Restrains, path[1:], condition)
+		result[path[0]] = restrain
+	case CardRestrain:
+		restrain.Condition = condition
+		result[path[0]] = restrain
+	case SetRestrain:
+		restrain.Condition = condition
+		result[path[0]] = restrain
+	case PropertyRestrain:
+		restrain.Condition = condition
+		result[path[0]] = restrain
+	case SizeRestrain:
+		restrain.Condition = condition
+		result[path[0]] = restrain
+	}
+	return result
+}
+
+func tunePathString(path []int) string {
+	parts := make([]string, 0, len(path))
+	for _, index := range path {
+		parts = append(parts, strconv.Itoa(index+1))
+	}
+	return strings.Join(parts, ".")
+}
+
+func conditionText(condition Condition) string {
+	return fmt.Sprintf("%v %d", condition.operator, condition.number)
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// TuneDeck tunes a deck of the current version on the identifier's test corpus.
+func (identifier *IdentifierWrapper) TuneDeck(deckName string) (*TuneReport, bool) {
+	tests, diagnostics := identifier.LoadTests()
+	report, ok := identifier.Current().TuneDeck(deckName, tests)
+	if ok {
+		report.Diagnostics = diagnostics
+	}
+	return report, ok
+}
@@ -0,0 +1,57 @@
+package ygopro_deck_identifier
+
+import "testing"
+
+const tuneDefinition = `
+set: 影依核心
+  影依猎鹰
+  影依·巨人
+  影依·米德拉什
+
+deck: 影依
+  set: 影依核心 main >= 2
+  tag: 极
+    config: prefix
+    card: 强欲之壶 >= 1
+
+deck: 机械
+  card: 机械士兵 main >= 2
+`
+
+func TestTuneLabel(t *testing.T) {
+	identifier := newTestIdentifier(t, tuneDefinition)
+	deckType := &identifier.Decks[0]
+	for label, want := range map[string]bool{"影依": true, "极影依": true, "极极影依": true, "影依极": false, "机械": false, "极机械": false} {
+		if got := identifier.tuneLabel(label, deckType); got != want {
+			t.Errorf("tuneLabel(%v, %v) = %v, want %v", label, deckType.Name, got, want)
+		}
+	}
+}
+
+func TestTuneDeckAffixedLabels(t *testing.T) {
+	identifier := newTestIdentifier(t, tuneDefinition)
+	tests := []DeckTest{
+		{ExpectDeck: "影依", Deck: testDeck(map[int]int{1001: 3, 1002: 1})},
+		{ExpectDeck: "极影依", Deck: testDeck(map[int]int{1001: 3, 1002: 2, 2003: 1})},
+		{ExpectDeck: "极影依", Deck: testDeck(map[int]int{1001: 2, 1003: 3, 2003: 1})},
+		{ExpectDeck: "机械", Deck: testDeck(map[int]int{2001: 3, 1001: 2})},
+		{ExpectDeck: "机械", Deck: testDeck(map[int]int{2001: 3, 1001: 3})},
+		{ExpectTags: []string{"极"}, Deck: testDeck(map[int]int{2003: 1})},
+	}
+	report, ok := identifier.TuneDeck("影依", tests)
+	if !ok {
+		t.Fatal("deck 影依 not found")
+	}
+	if report.Positive != 3 || report.Negative != 2 {
+		t.Fatalf("got %d positive and %d negative samples, want 3 and 2", report.Positive, report.Negative)
+	}
+	if len(report.Restrains) != 1 {
+		t.Fatalf("got %d restrains, want 1", len(report.Restrains))
+	}
+	if suggested := conditionText(report.Restrains[0].Suggested); suggested != ">= 4" {
+		t.Errorf("suggested %v, want >= 4", suggested)
+	}
+	if report.Current.FalsePositive != 2 || report.Suggested.FalsePositive != 0 || report.Suggested.TruePositive != 3 {
+		t.Errorf("current %+v, suggested %+v", report.Current, report.Suggested)
+	}
+}
@@ -0,0 +1,227 @@
+package ygopro_deck_identifier
+
+import (
+	"github.com/iamipanda/ygopro-data"
+	"sync"
+)
+
+const VECTOR_MAIN = 0
+const VECTOR_SIDE = 1
+const VECTOR_EX = 2
+const VECTOR_ORIGIN = 3
+const VECTOR_CARDS = 4
+const VECTOR_RANGES = 5
+
+// vectorRange mirrors GetDeckTargetClassifiedRange.
+func vectorRange(targetRange string) int {
+	switch targetRange {
+	case "main":
+		return VECTOR_MAIN
+	case "side":
+		return VECTOR_SIDE
+	case "ex", "extra":
+		return VECTOR_EX
+	case "ori", "origin":
+		return VECTOR_ORIGIN
+	default:
+		return VECTOR_CARDS
+	}
+}
+
+// cardSpace numbers every card mentioned by the definitions densely from 0, cards outside of it can't change any answer.
+type cardSpace struct {
+	index   map[int]int32
+	vectors sync.Pool
+}
+
+func newCardSpace() *cardSpace {
+	space := &cardSpace{index: make(map[int]int32)}
+	space.vectors.New = func() interface{} {
+		vector := new(deckVector)
+		for i := range vector.counts {
+			vector.counts[i] = make([]uint16, len(space.index))
+		}
+		return vector
+	}
+	return space
+}
+
+func (space *cardSpace) add(id int) int32 {
+	if index, ok := space.index[id]; ok {
+		return index
+	}
+	index := int32(len(space.index))
+	space.index[id] = index
+	return index
+}
+
+// deckVector is a deck converted into the card space, one count vector per range. Touched lists the
+// non-zero entries of each range, so a vector is cleared in the time it took to fill.
+type deckVector struct {
+	deck    *ygopro_data.Deck
+	counts  [VECTOR_RANGES][]uint16
+	touched [VECTOR_RANGES][]int32
+}
+
+// vectorize takes a vector from the pool, which must be handed back by release.
+func (space *cardSpace) vectorize(deck *ygopro_data.Deck) *deckVector {
+	vector := space.vectors.Get().(*deckVector)
+	vector.deck = deck
+	ranges := [VECTOR_RANGES]map[int]int{deck.ClassifiedMain, deck.ClassifiedSide, deck.ClassifiedEx, deck.ClassifiedOrigin, deck.ClassifiedCards}
+	for target, classified := range ranges {
+		for id, count := range classified {
+			if index, ok := space.index[id]; ok {
+				vector.counts[target][index] = uint16(count)
+				vector.touched[target] = append(vector.touched[target], index)
+			}
+		}
+	}
+	return vector
+}
+
+func (space *cardSpace) release(vector *deckVector) {
+	for target := range vector.touched {
+		for _, index := range vector.touched[target] {
+			vector.counts[target][index] = 0
+		}
+		vector.touched[target] = vector.touched[target][:0]
+	}
+	vector.deck = nil
+	space.vectors.Put(vector)
+}
+
+// compiledRestrain is a Restrain translated into the card space of an evaluation plan.
+type compiledRestrain interface {
+	judge(vector *deckVector) bool
+}
+
+type compiledCardRestrain struct {
+	index     int32
+	target    int
+	condition Condition
+}
+
+func (restrain compiledCardRestrain) judge(vector *deckVector) bool {
+	return restrain.condition.Judge(int(vector.counts[restrain.target][restrain.index]))
+}
+
+// compiledSetRestrain keeps the set both as indices and as a bitset. The count walks whichever side is shorter,
+// the set or the cards of the deck; the bitset side is only usable when the set holds no id twice.
+type compiledSetRestrain struct {
+	indices   []int32
+	bits      []uint64
+	unique    bool
+	target    int
+	condition Condition
+}
+
+func (restrain compiledSetRestrain) judge(vector *deckVector) bool {
+	counts := vector.counts[restrain.target]
+	touched := vector.touched[restrain.target]
+	count := 0
+	if restrain.unique && len(touched) < len(restrain.indices) {
+		for _, index := range touched {
+			if restrain.bits[index>>6]&(1<<uint(index&63)) != 0 {
+				count += int(counts[index])
+			}
+		}
+	} else {
+		for _, index := range restrain.indices {
+			count += int(counts[index])
+		}
+	}
+	return restrain.condition.Judge(count)
+}
+
+type compiledRestrainGroup struct {
+	restrains []compiledRestrain
+	condition Condition
+}
+
+func (restrain compiledRestrainGroup) judge(vector *deckVector) bool {
+	count := 0
+	for _, child := range restrain.restrains {
+		if child.judge(vector) {
+			count += 1
+		}
+	}
+	return restrain.condition.Judge(count)
+}
+
+// fallbackRestrain judges restrain types the card space doesn't know on the deck itself.
+type fallbackRestrain struct {
+	restrain Restrain
+}
+
+func (restrain fallbackRestrain) judge(vector *deckVector) bool {
+	return restrain.restrain.Judge(vector.deck)
+}
+
+// register adds the cards of the restrain to the space. Every restrain must be registered before any is compiled.
+func (space *cardSpace) register(restrain Restrain) {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		space.add(restrain.Id)
+	case SetRestrain:
+		for _, id := range restrain.Set.Ids {
+			space.add(id)
+		}
+	case PropertyRestrain:
+		space.register(restrain.setRestrain())
+	case RestrainGroup:
+		for _, child := range restrain.Restrains {
+			space.register(child)
+		}
+	}
+}
+
+func (space *cardSpace) compile(restrain Restrain) compiledRestrain {
+	switch restrain := restrain.(type) {
+	case CardRestrain:
+		return compiledCardRestrain{space.index[restrain.Id], vectorRange(restrain.Range), restrain.Condition}
+	case SetRestrain:
+		compiled := compiledSetRestrain{target: vectorRange(restrain.Range), condition: restrain.Condition, unique: true}
+		compiled.bits = make([]uint64, (len(space.index)+63)/64)
+		for _, id := range restrain.Set.Ids {
+			index := space.index[id]
+			if compiled.bits[index>>6]&(1<<uint(index&63)) != 0 {
+				compiled.unique = false
+			}
+			compiled.bits[index>>6] |= 1 << uint(index&63)
+			compiled.indices = append(compiled.indices, index)
+		}
+		return compiled
+	case PropertyRestrain:
+		return space.compile(restrain.setRestrain())
+	case RestrainGroup:
+		compiled := compiledRestrainGroup{condition: restrain.Condition}
+		for _, child := range restrain.Restrains {
+			compiled.restrains = append(compiled.restrains, space.compile(child))
+		}
+		return compiled
+	}
+	return fallbackRestrain{restrain}
+}
+
+// compiledClassification mirrors Classification.Judge: no restrain means no match.
+type compiledClassification []compiledRestrain
+
+func (space *cardSpace) compileClassification(classification Classification) compiledClassification {
+	compiled := make(compiledClassification, 0, len(classification.Restrains))
+	for _, restrain := range classification.Restrains {
+		compiled = append(compiled, space.compile(restrain))
+	}
+	return compiled
+}
+
+func (classification compiledClassification) judge(vector *deckVector) bool {
+	if len(classification) == 0 {
+		return false
+	}
+	for _, restrain := range classification {
+		if !restrain.judge(vector) {
+			return false
+		}
+	}
+	return true
+}
@@ -15,6 +15,8 @@ type VerboseRestrainAnswer struct {
 	value    int
 	is       bool
 	children []VerboseRestrainAnswer
+	// operands are the evaluated sides of a CompareRestrain.
+	operands []int
 }
 
 type VerboseDeckAnswer struct {
@@ -42,7 +44,7 @@ type VerboseResult struct {
 func (restrain CardRestrain) verboseJudge(deck *ygopro_data.Deck) VerboseRestrainAnswer {
 	target := GetDeckTargetClassifiedRange(deck, restrain.Range)
 	value := (*target)[restrain.Id]
-	return VerboseRestrainAnswer{restrain, value, restrain.Condition.Judge(value), nil}
+	return VerboseRestrainAnswer{restrain, value, restrain.Condition.Judge(value), nil, nil}
 }
 
 func (restrain SetRestrain) verboseJudge(deck *ygopro_data.Deck) VerboseRestrainAnswer {
@@ -57,7 +59,23 @@ func (restrain SetRestrain) verboseJudge(deck *ygopro_data.Deck) VerboseRestrain
 		Logger.Debugf("[%s] = %d", restrain.Range, len(*target))
 		Logger.Debugf("Count = %d", count)
 	}
-	return VerboseRestrainAnswer{restrain, count, restrain.Condition.Judge(count), nil}
+	return VerboseRestrainAnswer{restrain, count, restrain.Condition.Judge(count), nil, nil}
+}
+
+func (restrain PropertyRestrain) verboseJudge(deck *ygopro_data.Deck) VerboseRestrainAnswer {
+	answer := restrain.setRestrain().verboseJudge(deck)
+	answer.restrain = restrain
+	return answer
+}
+
+func (restrain SizeRestrain) verboseJudge(deck *ygopro_data.Deck) VerboseRestrainAnswer {
+	count := restrain.count(deck)
+	return VerboseRestrainAnswer{restrain, count, restrain.Condition.Judge(count), nil, nil}
+}
+
+func (restrain CompareRestrain) verboseJudge(deck *ygopro_data.Deck) VerboseRestrainAnswer {
+	left, right := restrain.Left.Evaluate(deck), restrain.Right.Evaluate(deck)
+	return VerboseRestrainAnswer{restrain, left - right, restrain.Condition.Judge(left - right), nil, []int{left, right}}
 }
 
 func (restrain RestrainGroup) verboseJudge(deck *ygopro_data.Deck) VerboseRestrainAnswer {
@@ -73,13 +91,13 @@ func (restrain RestrainGroup) verboseJudge(deck *ygopro_data.Deck) VerboseRestra
 		} else {
 			Logger.Warningf("No verbose judge defined for a restrain.")
 			pass := restrain.Judge(deck)
-			children = append(children, VerboseRestrainAnswer{restrain, -1, pass, nil})
+			children = append(children, VerboseRestrainAnswer{restrain, -1, pass, nil, nil})
 			if pass {
 				count += 1
 			}
 		}
 	}
-	return VerboseRestrainAnswer{restrain, count, restrain.Condition.Judge(count), children}
+	return VerboseRestrainAnswer{restrain, count, restrain.Condition.Judge(count), children, nil}
 }
 
 func (classification *Classification) verboseJudge(deck *ygopro_data.Deck) (bool, []VerboseRestrainAnswer) {
@@ -89,7 +107,7 @@ func (classification *Classification) verboseJudge(deck *ygopro_data.Deck) (bool
 			children = append(children, verboseRestrain.verboseJudge(deck))
 		} else {
 			Logger.Warningf("No verbose judge defined for a restrain.")
-			children = append(children, VerboseRestrainAnswer{restrain, -1, restrain.Judge(deck), nil})
+			children = append(children, VerboseRestrainAnswer{restrain, -1, restrain.Judge(deck), nil, nil})
 		}
 	}
 	is := true
@@ -1,10 +1,12 @@
 package ygopro_deck_identifier
 
 import (
+	"fmt"
 	"github.com/iamipanda/ygopro-data"
 	"path"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 type astIdentifier struct {
@@ -13,6 +15,7 @@ type astIdentifier struct {
 	sets  []*astNode
 
 	tagNameHash map[string]Tag
+	diagnostics Diagnostics
 }
 
 func (identifier *astIdentifier) registerNode(node *astNode) {
@@ -27,8 +30,12 @@ func (identifier *astIdentifier) registerNode(node *astNode) {
 		identifier.tags = append(identifier.tags, node)
 	case "set":
 		identifier.sets = append(identifier.sets, node)
+	case "import":
+		// Followed by the Identifier when the file is registered.
+	case "template", "use":
+		// Expanded by the Compiler already.
 	default:
-		Logger.Warning(originMessageLoggerHead(node) + " Unknown child node under Root node when register: " + node.Type)
+		identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", node, nil, "Unknown child node under Root node when register: %v", node.Type)
 	}
 }
 
@@ -37,6 +44,7 @@ func (identifier *astIdentifier) clear() {
 	identifier.tags = identifier.tags[:0]
 	identifier.sets = identifier.sets[:0]
 	identifier.tagNameHash = make(map[string]Tag)
+	identifier.diagnostics = nil
 }
 
 func (identifier *astIdentifier) prepare(target *Identifier, backup *Identifier) *Identifier {
@@ -66,7 +74,7 @@ func (identifier *astIdentifier) prepareSets(target *Identifier, backup *Identif
 		setNames[setNode.Value] = setNode
 	}
 	for _, setNode := range identifier.sets {
-		target.CustomSets = append(target.CustomSets, transformSet(setNode, &setNames, &sets, target))
+		target.CustomSets = append(target.CustomSets, identifier.transformSet(setNode, &setNames, &sets, target))
 	}
 	target.generateSetHash()
 }
@@ -83,7 +91,7 @@ func (identifier *astIdentifier) prepareDecks(target *Identifier, backup *Identi
 	}
 }
 
-func transformSet(node *astNode, nonConvertedSets *map[string]*astNode, convertedSets *map[string]ygopro_data.Set, target *Identifier) ygopro_data.Set {
+func (identifier *astIdentifier) transformSet(node *astNode, nonConvertedSets *map[string]*astNode, convertedSets *map[string]ygopro_data.Set, target *Identifier) ygopro_data.Set {
 	set := ygopro_data.Set{}
 	set.Locale = target.BindingEnvironment.Locale
 	set.Code = 0
@@ -97,7 +105,7 @@ func transformSet(node *astNode, nonConvertedSets *map[string]*astNode, converte
 					set.Ids = append(set.Ids, id)
 				}
 			} else if innerNode, ok := (*nonConvertedSets)[childNode.Value]; ok {
-				innerSet := transformSet(innerNode, nonConvertedSets, convertedSets, target)
+				innerSet := identifier.transformSet(innerNode, nonConvertedSets, convertedSets, target)
 				for _, id := range innerSet.Ids {
 					set.Ids = append(set.Ids, id)
 				}
@@ -107,49 +115,60 @@ func transformSet(node *astNode, nonConvertedSets *map[string]*astNode, converte
 					set.Ids = append(set.Ids, id)
 				}
 			} else {
-				Logger.Warning(originMessageLoggerHead(childNode) + " Unknown child node under Set node: " + childNode.Value)
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-set", childNode, nil, "Unknown inner set under Set node: %v", childNode.Value)
+			}
+		case "set code":
+			// Resolved against the cards of the environment, so a reloaded database brings its new archetype members.
+			if code, ok := parseSetCode(childNode.Value, target.BindingEnvironment); !ok {
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-setcode", childNode, nil, "Unknown setcode: %v", childNode.Value)
+			} else if ids := setCodeIds(code, target.BindingEnvironment); len(ids) > 0 {
+				// Members are added like those of inner sets and set cards: a card reached twice, by an archetype and
+				// its sub-archetype or by a setcode and a set card, is counted twice whatever the order of the lines.
+				set.Ids = append(set.Ids, ids...)
+			} else {
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "empty-setcode", childNode, nil, "No card has setcode %v (0x%x)", childNode.Value, code)
 			}
 		case "set card":
 			if card, ok := transformCard(childNode.Value, target.BindingEnvironment); ok {
 				set.Ids = append(set.Ids, card.Id)
 			} else {
-				Logger.Warning(originMessageLoggerHead(childNode) + " Can't find card named: " + childNode.Value)
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-card", childNode, nil, "Can't find card named: %v", childNode.Value)
 			}
 		default:
-			Logger.Warning(originMessageLoggerHead(childNode) + " Unknown child node under Set node: " + childNode.Type)
+			identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under Set node: %v", childNode.Type)
 		}
 	}
 	if len(set.Ids) == 0 {
-		Logger.Warningf(originMessageLoggerHead(node)+" Created an empty user defined Set named %v", set.Name)
+		identifier.diagnostics.report(DIAGNOSTIC_WARNING, "empty-set", node, nil, "Created an empty user defined Set named %v", set.Name)
 	} else {
 		Logger.Infof(originMessageLoggerHead(node)+" Created user defined Set named %v with %d Cards.", set.Name, len(set.Ids))
 	}
 	if _, ok := (*convertedSets)[node.Value]; ok {
-		Logger.Warningf("Rewriting existing set %v.", node.Value)
+		identifier.diagnostics.report(DIAGNOSTIC_WARNING, "set-redefined", node, nil, "Rewriting existing set %v.", node.Value)
 	}
 	(*convertedSets)[node.Value] = set
 	set.Sort()
 	return set
 }
 
-func transformRestrain(node *astNode, target *Identifier, backup *Identifier) Restrain {
+func (identifier *astIdentifier) transformRestrain(node *astNode, target *Identifier, backup *Identifier) Restrain {
 	switch node.Value {
 	case "card":
 		restrain := CardRestrain{}
 		for _, childNode := range node.Children {
 			switch childNode.Type {
 			case "condition":
-				restrain.Condition, _ = CreateConditionFromString(childNode.Value)
+				restrain.Condition = identifier.transformCondition(childNode, childNode.Value)
 			case "range":
 				restrain.Range = childNode.Value
 			case "target":
 				if card, ok := transformCard(childNode.Value, target.BindingEnvironment); ok {
 					restrain.Id = card.Id
 				} else {
-					Logger.Warning(originMessageLoggerHead(node) + " Can't find card named: " + childNode.Value)
+					identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-card", childNode, nil, "Can't find card named: %v", childNode.Value)
 				}
 			default:
-				Logger.Warning(originMessageLoggerHead(node) + " Unknown child node under card Restrain: " + childNode.Type)
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under card Restrain: %v", childNode.Type)
 			}
 		}
 		return restrain
@@ -158,23 +177,80 @@ func transformRestrain(node *astNode, target *Identifier, backup *Identifier) Re
 		for _, childNode := range node.Children {
 			switch childNode.Type {
 			case "condition":
-				restrain.Condition, _ = CreateConditionFromString(childNode.Value)
+				restrain.Condition = identifier.transformCondition(childNode, childNode.Value)
 			case "range":
 				restrain.Range = childNode.Value
 			case "target":
 				if set, ok := target.searchNamedSet(childNode.Value); ok {
 					restrain.Set = set
 				} else if backup != nil {
-					if set, ok := backup.searchNamedSet(childNode.Value); ok {
+					if set, ok := backup.lookupNamedSet(childNode.Value); ok {
 						restrain.Set = set
 					} else {
-						Logger.Warning(originMessageLoggerHead(node) + " Can't find set named " + childNode.Value)
+						identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-set", childNode, nil, "Can't find set named %v", childNode.Value)
 					}
 				} else {
-					Logger.Warning(originMessageLoggerHead(node) + " Can't find set named " + childNode.Value)
+					identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-set", childNode, nil, "Can't find set named %v", childNode.Value)
+				}
+			default:
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under set Restrain: %v", childNode.Type)
+			}
+		}
+		return restrain
+	case "property":
+		restrain := PropertyRestrain{}
+		for _, childNode := range node.Children {
+			switch childNode.Type {
+			case "condition":
+				restrain.Condition = identifier.transformCondition(childNode, childNode.Value)
+			case "range":
+				restrain.Range = childNode.Value
+			case "target":
+				filter, err := ParseCardFilter(childNode.Value, target.BindingEnvironment)
+				if err != nil {
+					identifier.diagnostics.report(DIAGNOSTIC_ERROR, "bad-filter", childNode, nil, "Can't realize the card filter {%v}: %v", childNode.Value, err)
+					continue
+				}
+				restrain.Filter = filter
+				restrain.Set = ygopro_data.Set{Name: "{" + filter.Text + "}", Ids: filter.Ids(target.BindingEnvironment)}
+				if len(restrain.Set.Ids) == 0 {
+					identifier.diagnostics.report(DIAGNOSTIC_WARNING, "empty-filter", childNode, nil, "No card matches the filter {%v}", childNode.Value)
+				}
+			default:
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under property Restrain: %v", childNode.Type)
+			}
+		}
+		return restrain
+	case "size", "distinct":
+		restrain := SizeRestrain{Distinct: node.Value == "distinct"}
+		for _, childNode := range node.Children {
+			switch childNode.Type {
+			case "condition":
+				restrain.Condition = identifier.transformCondition(childNode, childNode.Value)
+			case "range":
+				restrain.Range = childNode.Value
+			default:
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under %v Restrain: %v", node.Value, childNode.Type)
+			}
+		}
+		return restrain
+	case "compare":
+		restrain := CompareRestrain{}
+		for _, childNode := range node.Children {
+			switch childNode.Type {
+			case "condition":
+				restrain.Condition = NewCondition(childNode.Value, 0)
+			case "left", "right":
+				expression, err := ParseExpression(childNode.Value, identifier.expressionOperand(target, backup))
+				if err != nil {
+					identifier.diagnostics.report(DIAGNOSTIC_ERROR, "bad-expression", childNode, nil, "Can't realize the expression %v: %v", childNode.Value, err)
+				} else if childNode.Type == "left" {
+					restrain.Left = expression
+				} else {
+					restrain.Right = expression
 				}
 			default:
-				Logger.Warning(originMessageLoggerHead(node) + " Unknown child node under set Restrain: " + childNode.Type)
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under compare Restrain: %v", childNode.Type)
 			}
 		}
 		return restrain
@@ -182,9 +258,9 @@ func transformRestrain(node *astNode, target *Identifier, backup *Identifier) Re
 		restrain := RestrainGroup{}
 		for _, childNode := range node.Children {
 			if childNode.Type == "restrain" {
-				restrain.Restrains = append(restrain.Restrains, transformRestrain(childNode, target, backup))
+				restrain.Restrains = append(restrain.Restrains, identifier.transformRestrain(childNode, target, backup))
 			} else {
-				Logger.Warning(originMessageLoggerHead(childNode) + " non-restrain child node under group Restrain: " + childNode.Type)
+				identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "non-restrain child node under group Restrain: %v", childNode.Type)
 			}
 		}
 		if node.Value == "and" {
@@ -196,35 +272,72 @@ func transformRestrain(node *astNode, target *Identifier, backup *Identifier) Re
 	default:
 		if match := conditionStringReg.FindString(node.Value); len(match) > 0 {
 			restrain := RestrainGroup{}
-			restrain.Condition, _ = CreateConditionFromString(node.Value)
+			restrain.Condition = identifier.transformCondition(node, node.Value)
 			for _, childNode := range node.Children {
 				if childNode.Type == "restrain" {
-					restrain.Restrains = append(restrain.Restrains, transformRestrain(childNode, target, backup))
+					restrain.Restrains = append(restrain.Restrains, identifier.transformRestrain(childNode, target, backup))
 				} else {
-					Logger.Warning(originMessageLoggerHead(node) + " non-restrain child node under group Restrain: " + childNode.Type)
+					identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "non-restrain child node under group Restrain: %v", childNode.Type)
 				}
 			}
 			return restrain
 		} else {
-			Logger.Warning(originMessageLoggerHead(node) + " Unknown restrain type: " + node.Value)
+			identifier.diagnostics.report(DIAGNOSTIC_ERROR, "unknown-restrain", node, nil, "Unknown restrain type: %v", node.Value)
 		}
 	}
 	return CardRestrain{}
 }
 
+// expressionOperand resolves the operands of an expression the way the restrains resolve their targets.
+func (identifier *astIdentifier) expressionOperand(target *Identifier, backup *Identifier) func(string) ([]int, error) {
+	return func(operand string) ([]int, error) {
+		switch {
+		case strings.HasPrefix(operand, "[") && strings.HasSuffix(operand, "]"):
+			name := operand[1 : len(operand)-1]
+			if set, ok := target.searchNamedSet(name); ok {
+				return set.Ids, nil
+			} else if backup != nil {
+				if set, ok := backup.lookupNamedSet(name); ok {
+					return set.Ids, nil
+				}
+			}
+			return nil, fmt.Errorf("can't find set named %v", name)
+		case strings.HasPrefix(operand, "{") && strings.HasSuffix(operand, "}"):
+			filter, err := ParseCardFilter(operand[1:len(operand)-1], target.BindingEnvironment)
+			if err != nil {
+				return nil, err
+			}
+			return filter.Ids(target.BindingEnvironment), nil
+		}
+		if card, ok := transformCard(operand, target.BindingEnvironment); ok {
+			return []int{card.Id}, nil
+		}
+		return nil, fmt.Errorf("can't find card named %v", operand)
+	}
+}
+
+func (identifier *astIdentifier) transformCondition(node *astNode, value string) Condition {
+	condition, ok := CreateConditionFromString(value)
+	if !ok {
+		identifier.diagnostics.report(DIAGNOSTIC_ERROR, "bad-condition", node, nil, "Can't realize the condition string %v", value)
+	}
+	return condition
+}
+
 func (identifier *astIdentifier) transformTag(node *astNode, target *Identifier, backup *Identifier, checkEmpty bool) Tag {
 	tag := Tag{}
 	tag.Name = node.Value
+	tag.node = node
 	for _, childNode := range node.Children {
 		switch childNode.Type {
 		case "restrain":
-			tag.Restrains = append(tag.Restrains, transformRestrain(childNode, target, backup))
+			tag.Restrains = append(tag.Restrains, identifier.transformRestrain(childNode, target, backup))
 		case "config":
 			tag.Configs = append(tag.Configs, childNode.Value)
 		case "priority":
 			tag.Priority, _ = strconv.Atoi(childNode.Value)
 		default:
-			Logger.Warning(originMessageLoggerHead(childNode) + " Unknown child node under Tag node: " + childNode.Type)
+			identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under Tag node: %v", childNode.Type)
 		}
 	}
 	if checkEmpty && len(node.Children) == 0 {
@@ -235,7 +348,7 @@ func (identifier *astIdentifier) transformTag(node *astNode, target *Identifier,
 				return namedTag
 			}
 		}
-		Logger.Warning(originMessageLoggerHead(node) + " Empty Tag: " + tag.Name)
+		identifier.diagnostics.report(DIAGNOSTIC_WARNING, "empty-tag", node, nil, "Empty Tag: %v", tag.Name)
 	}
 	if tag.Is("global") {
 		target.GlobalTags = append(target.GlobalTags, tag)
@@ -246,10 +359,11 @@ func (identifier *astIdentifier) transformTag(node *astNode, target *Identifier,
 func (identifier *astIdentifier) transformDeck(node *astNode, target *Identifier, backup *Identifier) Deck {
 	deck := Deck{}
 	deck.Name = node.Value
+	deck.node = node
 	for _, childNode := range node.Children {
 		switch childNode.Type {
 		case "restrain":
-			deck.Restrains = append(deck.Restrains, transformRestrain(childNode, target, backup))
+			deck.Restrains = append(deck.Restrains, identifier.transformRestrain(childNode, target, backup))
 		case "check tag":
 			deck.CheckTags = append(deck.CheckTags, identifier.transformTag(childNode, target, backup, true))
 		case "force tag":
@@ -259,11 +373,11 @@ func (identifier *astIdentifier) transformDeck(node *astNode, target *Identifier
 		case "priority":
 			deck.Priority, _ = strconv.Atoi(childNode.Value)
 		default:
-			Logger.Warning(originMessageLoggerHead(node) + "Unknown child node under Deck node: " + childNode.Type)
+			identifier.diagnostics.report(DIAGNOSTIC_WARNING, "unknown-node", childNode, nil, "Unknown child node under Deck node: %v", childNode.Type)
 		}
 	}
 	if len(deck.Restrains) == 0 {
-		Logger.Warningf("%v No restrains registered to deck %v, there won't be deck named that.", originMessageLoggerHead(node), deck.Name)
+		identifier.diagnostics.report(DIAGNOSTIC_WARNING, "empty-deck", node, nil, "No restrains registered to deck %v, there won't be deck named that.", deck.Name)
 	}
 	return deck
 }
@@ -272,10 +386,8 @@ func transformCard(value string, environment *ygopro_data.Environment) (ygopro_d
 	id, err := strconv.Atoi(value)
 	if err == nil {
 		return environment.GetCard(id)
-	} else {
-		return environment.GetNamedCardCached(value)
 	}
-	return ygopro_data.Card{}, false
+	return environment.GetNamedCardCached(value)
 }
 
 func originMessageLoggerHead(node *astNode) string {